@@ -2,6 +2,7 @@
 set -e
 platforms=("linux/amd64" "darwin/amd64" "windows")
 dist_dir="dist"
+version=$(git describe --tags --always --dirty 2>/dev/null || echo "dev")
 
 for platform in "${platforms[@]}"
 do
@@ -16,5 +17,5 @@ do
     fi
 
     echo "Building for ${platform}: ${output_name}"
-    GOOS=${GOOS} GOARCH=${GOARCH} go build -o ${dist_dir}/"${output_name}" ./cmd/cake/main.go
+    GOOS=${GOOS} GOARCH=${GOARCH} go build -ldflags "-X github.com/mesosphere/cake-builder/pkg/cake.Version=${version}" -o ${dist_dir}/"${output_name}" ./cmd/cake/main.go
 done
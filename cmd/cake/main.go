@@ -3,13 +3,64 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/mesosphere/cake-builder/pkg/cake"
 )
 
+const usage = `Usage: cake [command] [flags] [arguments]
+
+Commands:
+  build                 Render templates, build and push images (default)
+  properties <image id> Show properties resolved for the image and where they come from
+  check                 Verify that generated Dockerfiles committed next to templates are up to date
+  clean                 Remove rendered Dockerfiles and templated files
+  lint                  Check templates, property definitions and rendered Dockerfiles for common problems
+  inspect <image>       Show how a published image was built and the chain of its ancestors
+  reproduce <image>     Rebuild a published image from the recorded revision and compare checksums and digests
+  lock [--update <id>]  Record checksums, base image digests and parent digests of images in cake.lock
+  outdated              Report newer tags and digests of external base images
+`
+
+// stringListFlag collects values of a flag which can be specified multiple times
+type stringListFlag []string
+
+func (values *stringListFlag) String() string {
+	return strings.Join(*values, ", ")
+}
+
+func (values *stringListFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+// configFlags are the flags shared by all commands which load cake.yaml and resolve image properties
+type configFlags struct {
+	releaseTag        *string
+	checksumLength    *int
+	propertiesFile    *string
+	renderDir         *string
+	propertyOverrides stringListFlag
+}
+
+// registryFlags are the flags of commands which access a Docker registry
+type registryFlags struct {
+	url           *string
+	username      *string
+	password      *string
+	passwordStdin *bool
+}
+
 func main() {
+	command, args := "build", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
 	currentDir, err := os.Getwd()
 	if err != nil {
@@ -17,72 +68,123 @@ func main() {
 	}
 	log.Println("Running in " + currentDir)
 
-	dryRun := flag.Bool("dry-run", false, "Resolves templates and calculates checksums without building or pushing images")
-	releaseTag := flag.String("release-tag", "latest", "Additional tag to republish checksum based images with e.g. a release tag")
-	outputFile := flag.String("out", currentDir+"/cake-report.json", "A file to save build report to")
-	registryUrl := flag.String("registry", "https://index.docker.io", "Docker registry URL")
-	dockerUser := flag.String("username", "", "Username to authenticate with Docker registry")
-	dockerPassword := flag.String("password", "", "Password to authenticate with Docker registry")
-	checksumLength := flag.Int("checksum-length", cake.DefaultShaLength,
-		fmt.Sprintf("Truncate the resulting checksum tag to the specified length within the interval [1, %d]. "+
-			"The recommended length of the truncated checksum is 8-10 characters.", cake.DefaultShaLength))
-
-	flag.Parse()
-
-	var config cake.BuildConfig
-	err = config.LoadConfigFromFile(currentDir + "/cake.yaml")
-	if err != nil {
-		log.Fatal(err)
+	switch command {
+	case "build":
+		build(currentDir, args)
+	case "properties":
+		properties(currentDir, args)
+	case "check":
+		check(currentDir, args)
+	case "clean":
+		clean(currentDir, args)
+	case "lint":
+		lint(currentDir, args)
+	case "inspect":
+		inspect(args)
+	case "reproduce":
+		reproduce(currentDir, args)
+	case "lock":
+		lock(currentDir, args)
+	case "outdated":
+		outdated(currentDir, args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n%s", command, usage)
+		os.Exit(2)
 	}
+}
 
-	if *checksumLength <= 0 || *checksumLength > 64 {
-		log.Fatalf("Invalid checksum length value. Expected value should be in the interval [1, %d] but was %d.", cake.DefaultShaLength, *checksumLength)
-	}
+func build(currentDir string, args []string) {
+	flags := newFlagSet("build")
+	dryRun := flags.Bool("dry-run", false, "Resolves templates and calculates checksums without building or pushing images")
+	outputFile := flags.String("out", currentDir+"/cake-report.json", "A file to save build report to")
+	registryFlags := registerRegistryFlags(flags)
+	provenance := flags.Bool("provenance", false, "Write SLSA provenance statements of pushed images to the provenance directory")
+	provenanceDir := flags.String("provenance-dir", cake.DefaultProvenanceDir, "A directory to write provenance statements to")
+	attachProvenance := flags.Bool("attach-provenance", false, "Attach provenance statements to pushed images in the registry (implies --provenance)")
+	pinDigests := flags.Bool("pin-digests", false, "Render parents of images as <name>:<tag>@<digest> (overrides pin_parent_digests from cake.yaml)")
+	memoryBudget := flags.String("memory-budget", "", "Maximum total memory declared by images built in parallel, e.g. 32g (overrides memory_budget from cake.yaml)")
+	frozen := flags.Bool("frozen", false, "Fail if checksums, base image digests or parent digests differ from the lock file")
+	lockFile := registerLockFileFlag(flags, currentDir)
+	configFlags := registerConfigFlags(flags)
+	flags.Parse(args)
 
-	config.BaseDir = currentDir
-	config.ReleaseTag = *releaseTag
+	config, buildGraph, _ := loadConfig(currentDir, configFlags)
 	config.OutputFile = *outputFile
-	config.AuthConfig = cake.AuthConfig{
-		DockerRegistryUrl: *registryUrl,
-		Username:          *dockerUser,
-		Password:          *dockerPassword,
+	config.AuthConfig = registryFlags.authConfig()
+	if len(*memoryBudget) > 0 {
+		config.MemoryBudget = *memoryBudget
+	}
+	if *pinDigests {
+		config.PinParentDigests = true
+	}
+	if *provenance || *attachProvenance {
+		config.ProvenanceDir = *provenanceDir
+		config.AttachProvenance = *attachProvenance
 	}
 
 	log.Println(config.Images)
-	log.Println(fmt.Sprintf("[build] dry run: %t, release tag: %s, output file: %s", *dryRun, *releaseTag, *outputFile))
+	log.Println(fmt.Sprintf("[build] dry run: %t, release tag: %s, output file: %s", *dryRun, config.ReleaseTag, *outputFile))
 
-	images, err := cake.TransformConfigToImages(config)
-	if err != nil {
-		log.Fatal(err)
+	// digests of base images are resolved via the registry, so the client is needed for dry runs as well
+	var dockerClient *cake.ExternalDockerClient
+	var baseDigestResolver cake.DockerClient
+	if !*dryRun || config.TrackBaseDigest {
+		dockerClient = cake.NewExternalDockerClient(config.AuthConfig)
+		defer dockerClient.Client.Close()
+		baseDigestResolver = dockerClient
 	}
 
-	buildGraph, err := cake.CreateImageBuildGraph(images)
-	if err != nil {
-		log.Fatal(err)
-	}
+	renderImages(buildGraph, config, *configFlags.checksumLength, baseDigestResolver)
 
-	cake.WalkBuildGraph(buildGraph, func(image *cake.Image) {
-		err = image.RenderDockerfileFromTemplate(config)
+	var lock *cake.LockFile
+	if *frozen {
+		var err error
+		lock, err = cake.LoadLockFile(*lockFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = image.CalculateChecksum(*checksumLength)
+		cake.WalkBuildGraph(buildGraph, func(image *cake.Image) {
+			if err := lock.Verify(image); err != nil {
+				log.Fatal(err)
+			}
+		})
+		log.Printf("Checksums and base image digests match %s", *lockFile)
+	}
+
+	if !*dryRun {
+		// credentials are looked up only for registries used by the rendered images
+		registryAuths, err := cake.ResolveRegistryAuths(config.AuthConfig, buildGraph)
 		if err != nil {
 			log.Fatal(err)
 		}
-	})
+		config.RegistryAuths = registryAuths
 
-	if !*dryRun {
-		dockerClient := cake.NewExternalDockerClient(config.AuthConfig)
-		defer dockerClient.Client.Close()
+		err = cake.WalkBuildGraphWithResources(buildGraph, config, func(image *cake.Image) {
+			// rendering the template again to resolve references to digests of the images pushed at previous levels
+			renderImage(image, config, *configFlags.checksumLength, dockerClient)
+
+			if lock != nil {
+				if err := lock.VerifyParentDigest(image); err != nil {
+					log.Fatal(err)
+				}
+			}
 
-		cake.WalkBuildGraphParallel(buildGraph, func(image *cake.Image) {
 			exists, err := cake.ImageExists(dockerClient, image, config)
 			if err != nil {
 				log.Fatal(err)
 			}
 
+			// digests of existing images are read from the registry to pin them in the children, record them
+			// in labels of the children, render them in templates referencing the image or verify them
+			if exists && (config.PinParentDigests || lock != nil || image.DigestLabeledByChildren() || image.ReferencedFromTemplates) {
+				err = cake.ResolveDigest(dockerClient, image, config)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+
 			if !exists {
+				started := time.Now()
 				err = cake.BuildImage(dockerClient, image, config)
 				if err != nil {
 					log.Fatal(err)
@@ -92,11 +194,544 @@ func main() {
 				if err != nil {
 					log.Fatal(err)
 				}
+
+				if len(config.ProvenanceDir) > 0 {
+					err = cake.RecordProvenance(dockerClient, image, config, started, time.Now())
+					if err != nil {
+						log.Fatal(err)
+					}
+				}
 			}
 		})
+		if err != nil {
+			log.Fatal(err)
+		}
 		err = cake.GenerateReport(buildGraph, config)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
+
+func properties(currentDir string, args []string) {
+	flags := newFlagSet("properties")
+	configFlags := registerConfigFlags(flags)
+	arguments := parseFlags(flags, args)
+
+	if len(arguments) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	config, buildGraph, images := loadConfig(currentDir, configFlags)
+	image, found := images[arguments[0]]
+	if !found {
+		log.Fatalf("Image with ID '%s' is not defined in the config", arguments[0])
+	}
+
+	renderImages(buildGraph, config, *configFlags.checksumLength, nil)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tVALUE\tSOURCE")
+	for _, property := range image.Properties.Properties() {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", property.Name, cake.FormatPropertyValue(property.Value), property.Source)
+	}
+	writer.Flush()
+}
+
+// check compares committed Dockerfiles with the rendered ones. Images are rendered in memory, so the check doesn't
+// modify the render directory.
+func check(currentDir string, args []string) {
+	flags := newFlagSet("check")
+	update := flags.Bool("update", false, "Overwrite out of date committed Dockerfiles with the rendered ones instead of failing")
+	configFlags := registerConfigFlags(flags)
+	flags.Parse(args)
+
+	config, buildGraph, _ := loadConfig(currentDir, configFlags)
+	config.RenderInMemory = true
+	renderImages(buildGraph, config, *configFlags.checksumLength, nil)
+
+	checked, outdated := 0, 0
+	cake.WalkBuildGraph(buildGraph, func(image *cake.Image) {
+		diff, found, err := image.CheckCommittedDockerfile()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !found {
+			return
+		}
+		checked++
+		if len(diff) == 0 {
+			return
+		}
+
+		outdated++
+		if *update {
+			err = image.UpdateCommittedDockerfile()
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Updated committed Dockerfile of image %s", image.ImageConfig.Id)
+		} else {
+			fmt.Print(diff)
+		}
+	})
+
+	if checked == 0 {
+		log.Println("No committed generated Dockerfiles found")
+		return
+	}
+	if outdated > 0 && !*update {
+		log.Printf("%d of %d committed Dockerfiles are out of date, run 'cake check --update' to update them", outdated, checked)
+		os.Exit(1)
+	}
+	log.Printf("%d committed Dockerfiles are up to date", checked-outdated)
+}
+
+func clean(currentDir string, args []string) {
+	flags := newFlagSet("clean")
+	renderDir := registerRenderDirFlag(flags)
+	flags.Parse(args)
+
+	config := cake.BuildConfig{
+		BaseDir:   currentDir,
+		RenderDir: *renderDir,
+	}
+	err := cake.CleanRenderDirectory(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func lint(currentDir string, args []string) {
+	flags := newFlagSet("lint")
+	format := flags.String("format", cake.TextLintFormat, fmt.Sprintf("Output format of the lint report: %s, %s or %s",
+		cake.TextLintFormat, cake.JsonLintFormat, cake.SarifLintFormat))
+	configFlags := registerConfigFlags(flags)
+	flags.Parse(args)
+
+	config, buildGraph, _ := loadConfig(currentDir, configFlags)
+	issues, err := cake.LintTemplates(buildGraph, config, "cake.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// templates with undefined properties can't be rendered, so Dockerfile rules are applied only to valid templates
+	renderable := true
+	for _, issue := range issues {
+		renderable = renderable && issue.Rule != cake.UndefinedPropertyRule
+	}
+	if renderable {
+		renderImages(buildGraph, config, *configFlags.checksumLength, nil)
+		dockerfileIssues, err := cake.LintDockerfiles(buildGraph, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		issues = append(issues, dockerfileIssues...)
+	} else {
+		log.Println("Skipping rendered Dockerfile checks because of undefined properties in templates")
+	}
+
+	err = cake.WriteLintReport(os.Stdout, issues, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(issues) > 0 {
+		log.Printf("Found %d issues in templates, properties and rendered Dockerfiles", len(issues))
+		os.Exit(1)
+	}
+	log.Println("No issues found in templates, properties and rendered Dockerfiles")
+}
+
+func inspect(args []string) {
+	flags := newFlagSet("inspect")
+	local := flags.Bool("local", false, "Read the image from the local Docker daemon instead of the registry")
+	format := flags.String("format", cake.TextFormat, fmt.Sprintf("Output format: %s or %s", cake.TextFormat, cake.JsonFormat))
+	registryFlags := registerRegistryFlags(flags)
+	arguments := parseFlags(flags, args)
+
+	if len(arguments) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var reader cake.ImageLabelsReader
+	if *local {
+		daemonClient := cake.NewDaemonClient()
+		defer daemonClient.Close()
+		reader = &cake.DaemonLabelsReader{Client: daemonClient}
+	} else {
+		dockerClient := cake.NewExternalDockerClient(registryFlags.authConfig())
+		defer dockerClient.Client.Close()
+		reader = &cake.RegistryLabelsReader{Client: dockerClient}
+	}
+
+	chain, err := cake.InspectImage(reader, arguments[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = cake.WriteLineage(os.Stdout, chain, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func reproduce(currentDir string, args []string) {
+	flags := newFlagSet("reproduce")
+	dryRun := flags.Bool("dry-run", false, "Compare checksums only without building the image")
+	registryFlags := registerRegistryFlags(flags)
+	arguments := parseFlags(flags, args)
+
+	if len(arguments) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	dockerClient := cake.NewExternalDockerClient(registryFlags.authConfig())
+	defer dockerClient.Client.Close()
+	registryReader := &cake.RegistryLabelsReader{Client: dockerClient}
+
+	chain, err := cake.InspectImage(registryReader, arguments[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(chain[0].Revision) == 0 {
+		log.Fatalf("Image %s has no git revision label and can't be reproduced", arguments[0])
+	}
+
+	worktree, cleanup, err := cake.CheckoutWorktree(currentDir, chain[0].Revision)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := reproduceImage(dockerClient, registryReader, worktree, chain, *dryRun)
+	cleanup()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "image:\t%s (%s)\n", report.Image, report.ImageId)
+	fmt.Fprintf(writer, "revision:\t%s\n", report.Revision)
+	fmt.Fprintf(writer, "checksum:\tpublished %s, reproduced %s (%s)\n", report.PublishedChecksum, report.Checksum, matchStatus(report.ChecksumMatches()))
+	if !*dryRun {
+		fmt.Fprintf(writer, "digest:\tpublished %s, reproduced %s (%s)\n", report.PublishedDigest, report.Digest, matchStatus(report.DigestMatches()))
+	}
+	writer.Flush()
+
+	if !report.ChecksumMatches() {
+		os.Exit(1)
+	}
+}
+
+// lock renders all images and records their checksums along with base image and parent digests in the lock file
+func lock(currentDir string, args []string) {
+	flags := newFlagSet("lock")
+	update := flags.Bool("update", false, "Update entries of the given images only and keep the other entries of the lock file")
+	lockFile := registerLockFileFlag(flags, currentDir)
+	registryFlags := registerRegistryFlags(flags)
+	configFlags := registerConfigFlags(flags)
+	ids := parseFlags(flags, args)
+
+	if *update && len(ids) == 0 || !*update && len(ids) > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	config, buildGraph, _ := loadConfig(currentDir, configFlags)
+	config.AuthConfig = registryFlags.authConfig()
+	dockerClient := cake.NewExternalDockerClient(config.AuthConfig)
+	defer dockerClient.Client.Close()
+
+	renderImages(buildGraph, config, *configFlags.checksumLength, dockerClient)
+
+	lock := &cake.LockFile{Images: make(map[string]cake.LockedImage)}
+	if *update {
+		var err error
+		lock, err = cake.LoadLockFile(*lockFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err := cake.LockImages(dockerClient, buildGraph, config, lock, ids)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = lock.Write(*lockFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Lock file written to %s", *lockFile)
+}
+
+func outdated(currentDir string, args []string) {
+	flags := newFlagSet("outdated")
+	format := flags.String("format", cake.TextFormat, fmt.Sprintf("Output format: %s or %s", cake.TextFormat, cake.JsonFormat))
+	lockFile := registerLockFileFlag(flags, currentDir)
+	registryFlags := registerRegistryFlags(flags)
+	configFlags := registerConfigFlags(flags)
+	flags.Parse(args)
+
+	config, buildGraph, _ := loadConfig(currentDir, configFlags)
+	renderImages(buildGraph, config, *configFlags.checksumLength, nil)
+
+	// digests recorded in the lock file are compared with the current ones if the project is locked
+	var lock *cake.LockFile
+	if _, err := os.Stat(*lockFile); err == nil {
+		lock, err = cake.LoadLockFile(*lockFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	dockerClient := cake.NewExternalDockerClient(registryFlags.authConfig())
+	defer dockerClient.Client.Close()
+	updates, err := cake.FindOutdatedBaseImages(dockerClient, buildGraph, lock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = cake.WriteOutdatedBaseImages(os.Stdout, updates, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Found %d updates of external base images", len(updates))
+}
+
+// reproduceImage renders the images of the project checked out at the recorded revision with the recorded properties
+// and rebuilds the image unless it's a dry run. The rebuilt image is not pushed.
+func reproduceImage(dockerClient *cake.ExternalDockerClient, registryReader cake.ImageLabelsReader, baseDir string,
+	chain []cake.ImageLineage, dryRun bool) (cake.ReproductionReport, error) {
+	published := chain[0]
+	report := cake.ReproductionReport{
+		Image:             published.Reference,
+		ImageId:           published.ImageId,
+		Revision:          published.Revision,
+		PublishedChecksum: published.Checksum,
+	}
+
+	var config cake.BuildConfig
+	err := config.LoadConfigFromFile(baseDir + "/cake.yaml")
+	if err != nil {
+		return report, err
+	}
+	config.BaseDir = baseDir
+	config.AuthConfig = dockerClient.AuthConfig
+	config.PropertyOverrides = cake.ReproductionOverrides(chain)
+	// the creation time is a part of the image config, so it must match the published one for digests to match
+	config.CreatedTime = published.Created
+
+	buildGraph, images, err := createBuildGraph(config)
+	if err != nil {
+		return report, err
+	}
+	image, found := images[published.ImageId]
+	if !found {
+		return report, fmt.Errorf("image with ID '%s' is not defined in the config at revision %s", published.ImageId, published.Revision)
+	}
+	config.ReleaseTag = cake.ReleaseTagFromReference(image, published.Reference, published.Checksum)
+	if cake.RestoreParentDigests(chain, images) {
+		config.PinParentDigests = true
+	}
+	cake.RestoreBaseDigests(chain, images)
+
+	var renderErr error
+	cake.WalkBuildGraph(buildGraph, func(image *cake.Image) {
+		if renderErr == nil {
+			renderErr = image.RenderDockerfileFromTemplate(config)
+		}
+		if renderErr == nil {
+			renderErr = cake.ResolveBaseDigests(dockerClient, image, config)
+		}
+		if renderErr == nil {
+			renderErr = image.CalculateChecksum(len(published.Checksum))
+		}
+	})
+	if renderErr != nil {
+		return report, renderErr
+	}
+	report.Checksum = image.Checksum
+	if dryRun {
+		return report, nil
+	}
+
+	name, reference := cake.SplitImageReference(published.Reference)
+	report.PublishedDigest, err = registryReader.ConfigDigest(name, reference)
+	if err != nil {
+		return report, err
+	}
+
+	config.RegistryAuths, err = cake.ResolveRegistryAuths(config.AuthConfig, buildGraph)
+	if err != nil {
+		return report, err
+	}
+
+	err = cake.BuildImage(dockerClient, image, config)
+	if err != nil {
+		return report, err
+	}
+	daemonReader := &cake.DaemonLabelsReader{Client: dockerClient.Client}
+	report.Digest, err = daemonReader.ConfigDigest(cake.SplitImageReference(image.StableReference(config)))
+	return report, err
+}
+
+func matchStatus(matches bool) string {
+	if matches {
+		return "match"
+	}
+	return "mismatch"
+}
+
+func newFlagSet(command string) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		fmt.Fprintf(flags.Output(), "\nFlags of '%s' command:\n", command)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses flags which can be interleaved with positional arguments and returns the positional arguments
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	var arguments []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return arguments
+		}
+		arguments = append(arguments, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func registerConfigFlags(flags *flag.FlagSet) *configFlags {
+	configFlags := &configFlags{
+		releaseTag: flags.String("release-tag", "latest", "Additional tag to republish checksum based images with e.g. a release tag"),
+		checksumLength: flags.Int("checksum-length", cake.DefaultShaLength,
+			fmt.Sprintf("Truncate the resulting checksum tag to the specified length within the interval [1, %d]. "+
+				"The recommended length of the truncated checksum is 8-10 characters.", cake.DefaultShaLength)),
+		propertiesFile: flags.String("properties-file", "", "A YAML file with property overrides in the form of 'key: value' or 'id.key: value'"),
+		renderDir:      registerRenderDirFlag(flags),
+	}
+	flags.Var(&configFlags.propertyOverrides, "set", "Override a property for all images with 'key=value' or for a specific image with 'id.key=value'. "+
+		"Can be specified multiple times and takes precedence over --properties-file")
+	return configFlags
+}
+
+func registerRegistryFlags(flags *flag.FlagSet) *registryFlags {
+	return &registryFlags{
+		url:           flags.String("registry", "https://index.docker.io", "Docker registry URL"),
+		username:      flags.String("username", "", "Username to authenticate with Docker registry"),
+		password:      flags.String("password", "", "Password to authenticate with Docker registry"),
+		passwordStdin: flags.Bool("password-stdin", false, "Read the password to authenticate with Docker registry from stdin"),
+	}
+}
+
+// authConfig returns the credentials given via flags. Missing credentials are read from the Docker config only when
+// the registry is accessed.
+func (flags *registryFlags) authConfig() cake.AuthConfig {
+	authConfig := cake.AuthConfig{
+		DockerRegistryUrl: *flags.url,
+		Username:          *flags.username,
+		Password:          *flags.password,
+	}
+	if *flags.passwordStdin {
+		if len(*flags.password) > 0 {
+			log.Fatal("--password and --password-stdin are mutually exclusive")
+		}
+		password, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authConfig.Password = strings.TrimRight(string(password), "\r\n")
+	}
+	return authConfig
+}
+
+func registerLockFileFlag(flags *flag.FlagSet, currentDir string) *string {
+	return flags.String("lock-file", currentDir+"/"+cake.DefaultLockFile, "A file to record resolved checksums and digests in")
+}
+
+func registerRenderDirFlag(flags *flag.FlagSet) *string {
+	return flags.String("render-dir", cake.DefaultRenderDir, "A directory within the project to render Dockerfiles and templated files to. "+
+		"Files of every image are rendered to a subdirectory named after the image ID")
+}
+
+// loadConfig reads cake.yaml from the base directory, applies property overrides and creates the build graph
+func loadConfig(baseDir string, configFlags *configFlags) (cake.BuildConfig, *cake.Image, map[string]*cake.Image) {
+	var config cake.BuildConfig
+	err := config.LoadConfigFromFile(baseDir + "/cake.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(*configFlags.propertiesFile) > 0 {
+		err = config.LoadPropertiesFromFile(*configFlags.propertiesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, assignment := range configFlags.propertyOverrides {
+		err = config.AddPropertyOverride(assignment, "--set")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *configFlags.checksumLength <= 0 || *configFlags.checksumLength > 64 {
+		log.Fatalf("Invalid checksum length value. Expected value should be in the interval [1, %d] but was %d.", cake.DefaultShaLength, *configFlags.checksumLength)
+	}
+
+	config.BaseDir = baseDir
+	config.RenderDir = *configFlags.renderDir
+	config.ReleaseTag = *configFlags.releaseTag
+
+	buildGraph, images, err := createBuildGraph(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return config, buildGraph, images
+}
+
+// createBuildGraph creates images from the config and links them into the build graph
+func createBuildGraph(config cake.BuildConfig) (*cake.Image, map[string]*cake.Image, error) {
+	images, err := cake.TransformConfigToImages(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buildGraph, err := cake.CreateImageBuildGraph(images)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = cake.AddImplicitDependencies(images, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buildGraph, images, nil
+}
+
+// renderImages renders Dockerfiles from templates and calculates checksums for all images in the build graph.
+// Digests of base images are resolved only if a Docker client is provided.
+func renderImages(buildGraph *cake.Image, config cake.BuildConfig, checksumLength int, dockerClient cake.DockerClient) {
+	cake.WalkBuildGraph(buildGraph, func(image *cake.Image) {
+		renderImage(image, config, checksumLength, dockerClient)
+	})
+}
+
+func renderImage(image *cake.Image, config cake.BuildConfig, checksumLength int, dockerClient cake.DockerClient) {
+	err := image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dockerClient != nil {
+		err = cake.ResolveBaseDigests(dockerClient, image, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = image.CalculateChecksum(checksumLength)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,3 +2,4 @@
 set -e -u
 
 echo "setting up environment"
+export SPARK_VERSION={{spark_version}}
@@ -0,0 +1,89 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// externalBaseImages returns references of external images used in FROM instructions of the rendered Dockerfile
+// by line number. Previous build stages, scratch, references with build arguments and references already pinned
+// by digest are skipped.
+func externalBaseImages(content string) map[int]string {
+	references := make(map[int]string)
+	for _, from := range baseImageReferences(parseDockerfile(content)) {
+		if !strings.Contains(from.reference, "@") {
+			references[from.line] = from.reference
+		}
+	}
+	return references
+}
+
+// registryRepository returns the name of an external image including the registry host. Images without a host are
+// located on Docker Hub, official Docker Hub images are located in the 'library' repository.
+func registryRepository(name string) string {
+	host, path := splitRegistryHost(name)
+	if len(host) == 0 {
+		host = "docker.io"
+	}
+	if registryHost(host) == "index.docker.io" && !strings.Contains(path, "/") {
+		path = "library/" + path
+	}
+	return host + "/" + path
+}
+
+// ResolveBaseDigests resolves digests of external base images of a root image via the registry. The digests are
+// included in the image checksum, so the image is rebuilt when an upstream tag is updated. If pinning is enabled,
+// FROM instructions of the rendered Dockerfile are rewritten to reference the base images by digest.
+// Digests resolved during previous renderings of the image are reused.
+func ResolveBaseDigests(dockerClient DockerClient, image *Image, config BuildConfig) error {
+	if !config.TrackBaseDigest || image.Parent != nil {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(image.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("failed to read rendered Dockerfile of image %s: %v", image.ImageConfig.Id, err)
+	}
+
+	references := externalBaseImages(string(content))
+	if image.BaseDigests == nil {
+		image.BaseDigests = make(map[string]string)
+	}
+	for _, reference := range references {
+		if _, resolved := image.BaseDigests[reference]; resolved {
+			continue
+		}
+		name, tag := SplitImageReference(reference)
+		digest, err := dockerClient.ManifestDigest(registryRepository(name), tag)
+		if err != nil {
+			return fmt.Errorf("unable to resolve digest of base image %s of image %s: %v", reference, image.ImageConfig.Id, err)
+		}
+		log.Printf("Resolved base image %s of image %s to digest %s", reference, image.ImageConfig.Id, digest)
+		image.BaseDigests[reference] = digest
+	}
+
+	if !config.PinBaseDigest || len(references) == 0 {
+		return nil
+	}
+	if image.isPlainDockerfile() {
+		log.Printf("Base images of image %s are not pinned, plain Dockerfiles are not rewritten", image.ImageConfig.Id)
+		return nil
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for line, reference := range references {
+		lines[line-1] = strings.Replace(lines[line-1], reference, reference+"@"+image.BaseDigests[reference], 1)
+	}
+	info, err := os.Stat(image.Dockerfile)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(image.Dockerfile, []byte(strings.Join(lines, "\n")), info.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to pin base images of image %s: %v", image.ImageConfig.Id, err)
+	}
+	return nil
+}
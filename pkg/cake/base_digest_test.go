@@ -0,0 +1,92 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExternalBaseImages(t *testing.T) {
+	content := `FROM --platform=linux/amd64 golang:1.13 AS builder
+RUN go build ./...
+
+FROM builder AS test
+FROM scratch
+FROM ${BASE_IMAGE}
+FROM alpine@sha256:123
+FROM ubuntu:18.04
+`
+	assert.Equal(t, map[int]string{1: "golang:1.13", 8: "ubuntu:18.04"}, externalBaseImages(content))
+}
+
+func TestResolveBaseDigests(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "base")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	templateFile := path.Join(baseDir, "root", "Dockerfile.template")
+	err = os.MkdirAll(path.Dir(templateFile), os.ModePerm)
+	if err != nil {
+		t.Errorf("Failed to create directory: %v", err)
+	}
+	err = ioutil.WriteFile(templateFile, []byte("FROM golang:1.13 AS builder\nFROM quay.io/org/base\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := &Image{ImageConfig: ImageConfig{Id: "root", Repository: "repo", Name: "root", Template: templateFile}}
+	config := BuildConfig{BaseDir: baseDir}
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	untracked := image.Checksum
+
+	dockerClient := &MockDockerClient{MockManifestDigest: "sha256:123"}
+	err = ResolveBaseDigests(dockerClient, image, config)
+	if err != nil {
+		t.Errorf("Unexpected error while resolving base digests: %v", err)
+	}
+	assert.Nil(t, image.BaseDigests, "base digests are resolved only if tracking is enabled")
+
+	config.TrackBaseDigest = true
+	err = ResolveBaseDigests(dockerClient, image, config)
+	if err != nil {
+		t.Errorf("Unexpected error while resolving base digests: %v", err)
+	}
+	assert.ElementsMatch(t, []string{"docker.io/library/golang:1.13", "quay.io/org/base:latest"}, dockerClient.ManifestRequests)
+	assert.Equal(t, map[string]string{"golang:1.13": "sha256:123", "quay.io/org/base": "sha256:123"}, image.BaseDigests)
+
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	assert.NotEqual(t, untracked, image.Checksum, "base digests must be included in the checksum")
+
+	// digests are not resolved again and the rendered Dockerfile is rewritten if pinning is enabled
+	dockerClient.ManifestRequests = nil
+	config.PinBaseDigest = true
+	err = ResolveBaseDigests(dockerClient, image, config)
+	if err != nil {
+		t.Errorf("Unexpected error while resolving base digests: %v", err)
+	}
+	assert.Empty(t, dockerClient.ManifestRequests)
+	assertFileContents(t, image.Dockerfile, "FROM golang:1.13@sha256:123 AS builder\nFROM quay.io/org/base@sha256:123\n")
+
+	// children use cake images as parents, their base images are not tracked
+	child := &Image{ImageConfig: ImageConfig{Id: "child"}, Parent: image}
+	err = ResolveBaseDigests(dockerClient, child, config)
+	if err != nil {
+		t.Errorf("Unexpected error while resolving base digests: %v", err)
+	}
+	assert.Nil(t, child.BaseDigests)
+}
@@ -0,0 +1,82 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// committedDockerfile returns the location of the generated Dockerfile which can be committed next to the template
+// for review visibility. Prefix and suffix are included into the file name to distinguish images sharing a template.
+func (image *Image) committedDockerfile() string {
+	dockerfile := filepath.Join(filepath.Dir(image.ImageConfig.Template), GeneratedDockerFileNamePrefix)
+	if len(image.ImageConfig.TagPrefix) > 0 {
+		dockerfile = fmt.Sprintf("%s.%s", dockerfile, image.ImageConfig.TagPrefix)
+	}
+	if len(image.ImageConfig.TagSuffix) > 0 {
+		dockerfile = fmt.Sprintf("%s.%s", dockerfile, image.ImageConfig.TagSuffix)
+	}
+	return dockerfile
+}
+
+// committedDockerfiles returns locations of committed Dockerfiles of all templated images of the build graph the image
+// belongs to. Images sharing a template directory must not include each other's committed Dockerfiles in checksums.
+func (image *Image) committedDockerfiles() []string {
+	var dockerfiles []string
+	WalkBuildGraph(image.root(), func(graphImage *Image) {
+		if !graphImage.isPlainDockerfile() {
+			dockerfiles = append(dockerfiles, graphImage.committedDockerfile())
+		}
+	})
+	return dockerfiles
+}
+
+// CheckCommittedDockerfile compares the rendered Dockerfile of the image with the generated Dockerfile committed
+// next to its template and returns a unified diff or an empty string if the committed Dockerfile is up to date.
+// Images without a committed Dockerfile or built from plain Dockerfiles are not checked which is indicated
+// by the returned flag.
+func (image *Image) CheckCommittedDockerfile() (string, bool, error) {
+	if image.isPlainDockerfile() {
+		return "", false, nil
+	}
+	committed, err := ioutil.ReadFile(image.committedDockerfile())
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read committed Dockerfile: %v", err)
+	}
+
+	rendered, err := image.readRenderedFile(image.Dockerfile)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read rendered Dockerfile: %v", err)
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(committed)),
+		B:        difflib.SplitLines(string(rendered)),
+		FromFile: image.committedDockerfile(),
+		ToFile:   fmt.Sprintf("%s (rendered for %s)", image.ImageConfig.Template, image.ImageConfig.Id),
+		Context:  3,
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to compare Dockerfiles: %v", err)
+	}
+	return diff, true, nil
+}
+
+// UpdateCommittedDockerfile overwrites the generated Dockerfile committed next to the template with the rendered one
+func (image *Image) UpdateCommittedDockerfile() error {
+	rendered, err := image.readRenderedFile(image.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("failed to read rendered Dockerfile: %v", err)
+	}
+	err = ioutil.WriteFile(image.committedDockerfile(), rendered, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to update committed Dockerfile: %v", err)
+	}
+	return nil
+}
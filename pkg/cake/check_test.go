@@ -0,0 +1,145 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCheckCommittedDockerfile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte("FROM ubuntu:{{ubuntu_version}}\nRUN echo hello\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := Image{
+		ImageConfig: ImageConfig{
+			Id:         "image",
+			Template:   templateFile,
+			TagSuffix:  "dev",
+			Properties: Properties{"ubuntu_version": "18.04"},
+		},
+	}
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	_, found, err := image.CheckCommittedDockerfile()
+	if err != nil {
+		t.Errorf("Unexpected error while checking committed Dockerfile: %v", err)
+	}
+	if found {
+		t.Errorf("Expected images without a committed Dockerfile not to be checked")
+	}
+
+	committedDockerfile := path.Join(tmpDir, GeneratedDockerFileNamePrefix+".dev")
+	err = ioutil.WriteFile(committedDockerfile, []byte("FROM ubuntu:16.04\nRUN echo hello\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	diff, found, err := image.CheckCommittedDockerfile()
+	if err != nil {
+		t.Errorf("Unexpected error while checking committed Dockerfile: %v", err)
+	}
+	if !found {
+		t.Errorf("Expected committed Dockerfile %s to be checked", committedDockerfile)
+	}
+	if !strings.Contains(diff, "-FROM ubuntu:16.04\n") || !strings.Contains(diff, "+FROM ubuntu:18.04\n") {
+		t.Errorf("Expected unified diff of the committed and rendered Dockerfiles but found:\n%s", diff)
+	}
+
+	err = image.UpdateCommittedDockerfile()
+	if err != nil {
+		t.Errorf("Unexpected error while updating committed Dockerfile: %v", err)
+	}
+	assertFileContents(t, committedDockerfile, "FROM ubuntu:18.04\nRUN echo hello\n")
+
+	diff, found, err = image.CheckCommittedDockerfile()
+	if err != nil {
+		t.Errorf("Unexpected error while checking committed Dockerfile: %v", err)
+	}
+	if !found || len(diff) > 0 {
+		t.Errorf("Expected committed Dockerfile to be up to date but found diff:\n%s", diff)
+	}
+}
+
+func TestRenderInMemory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte("FROM ubuntu:{{ubuntu_version}}\nCOPY {{generated_dir}}/env.sh /env.sh\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	templatedFile := "env.sh"
+	err = ioutil.WriteFile(path.Join(tmpDir, templatedFile), []byte("export UBUNTU={{ubuntu_version}}\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Errorf("Failed to get working directory: %v", err)
+	}
+	defer os.Chdir(currentDir)
+	os.Chdir(tmpDir)
+
+	newImage := func() *Image {
+		return &Image{ImageConfig: ImageConfig{
+			Id:             "image",
+			Template:       templateFile,
+			TemplatedFiles: []string{templatedFile},
+			Properties:     Properties{"ubuntu_version": "18.04"},
+		}}
+	}
+
+	inMemory := newImage()
+	err = inMemory.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir, RenderInMemory: true})
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	err = inMemory.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	if _, err = os.Stat(path.Join(tmpDir, DefaultRenderDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written to the render directory")
+	}
+
+	//checksums don't depend on whether the files are written to disk
+	onDisk := newImage()
+	err = onDisk.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	err = onDisk.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	if inMemory.Checksum != onDisk.Checksum {
+		t.Errorf("Checksum of the image rendered in memory %s differs from the one rendered to disk %s", inMemory.Checksum, onDisk.Checksum)
+	}
+
+	err = ioutil.WriteFile(inMemory.committedDockerfile(), []byte("FROM ubuntu:16.04\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	diff, found, err := inMemory.CheckCommittedDockerfile()
+	if err != nil || !found || !strings.Contains(diff, "+FROM ubuntu:18.04\n") {
+		t.Errorf("Expected committed Dockerfile to be compared with the one rendered in memory but found diff:\n%s", diff)
+	}
+}
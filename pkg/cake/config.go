@@ -5,21 +5,49 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
 
 type ImageConfig struct {
-	Id            string
-	Parent        string
-	Repository    string
-	Name          string
-	TagPrefix     string `yaml:"tag_prefix"`
-	TagSuffix     string `yaml:"tag_suffix"`
-	Template      string
-	ExtraFiles    []string `yaml:"extra_files"`
-	ExcludedFiles []string `yaml:"exclude_files"`
-	Properties    map[string]string
+	Id             string
+	Parent         string
+	Repository     string
+	Name           string
+	TagPrefix      string `yaml:"tag_prefix"`
+	TagSuffix      string `yaml:"tag_suffix"`
+	Template       string
+	Dockerfile     string
+	Engine         string
+	ExtraFiles     []string `yaml:"extra_files"`
+	ExcludedFiles  []string `yaml:"exclude_files"`
+	TemplatedFiles []string `yaml:"templated_files"`
+	Lint           LintConfig
+	Resources      ResourcesConfig
+	Properties     Properties
+
+	// Docker build settings, the ones affecting image contents are included in the checksum
+	BuildArgs   map[string]string `yaml:"build_args"`
+	Target      string
+	Labels      map[string]string
+	Platform    string
+	NetworkMode string `yaml:"network_mode"`
+	NoCache     bool   `yaml:"no_cache"`
+	Pull        bool
+	Squash      bool
+	// OCI and cake labels are added to the image unless set to false
+	AutoLabels *bool `yaml:"auto_labels"`
+}
+
+// LintConfig configures lint rules applied to templates and rendered Dockerfiles
+type LintConfig struct {
+	// IDs of rules which are not reported
+	Disable []string
 }
 
 func (image ImageConfig) String() string {
@@ -36,13 +64,81 @@ type AuthConfig struct {
 	Password          string
 }
 
+// PropertyOverride is a property value supplied outside of cake.yaml, e.g. via --set flag or a properties file.
+// Overrides with an empty Image apply to all images, otherwise only to the image with the matching ID.
+// Values supplied via flags and properties files are strings, structured values come from image labels.
+type PropertyOverride struct {
+	Image  string
+	Key    string
+	Value  interface{}
+	Source string
+}
+
 type BuildConfig struct {
-	AuthConfig       AuthConfig
-	BaseDir          string
-	ReleaseTag       string
-	OutputFile       string
-	Images           []ImageConfig     `yaml:"images"`
-	GlobalProperties map[string]string `yaml:"global_properties"`
+	AuthConfig AuthConfig
+	BaseDir    string
+	ReleaseTag string
+	OutputFile string
+	RenderDir  string
+	// render Dockerfiles and templated files in memory instead of writing them to the render directory
+	RenderInMemory bool
+	// credentials of all registries passed to builds by registry host
+	RegistryAuths map[string]AuthConfig
+	// directory provenance statements of pushed images are written to, empty if provenance is not recorded
+	ProvenanceDir string
+	// attach provenance statements to pushed images in the registry
+	AttachProvenance bool
+	// creation time recorded in labels instead of the one derived from the sources, in RFC 3339 format
+	CreatedTime       string
+	PropertyOverrides []PropertyOverride
+	Engine            string          `yaml:"engine"`
+	PartialsDir       string          `yaml:"partials_dir"`
+	Lint              LintConfig      `yaml:"lint"`
+	Resources         ResourcesConfig `yaml:"resources"`
+	// render parents as '<repository>/<name>:<tag>@<digest>' once their digests are known
+	PinParentDigests bool `yaml:"pin_parent_digests"`
+	// resolve digests of external base images of root images and include them in the checksum
+	TrackBaseDigest bool `yaml:"track_base_digest"`
+	// rewrite FROM instructions of root images to reference external base images by the resolved digest
+	PinBaseDigest bool `yaml:"pin_base_digest"`
+	// maximum total memory declared by images built in parallel, e.g. '32g'
+	MemoryBudget     string        `yaml:"memory_budget"`
+	Images           []ImageConfig `yaml:"images"`
+	GlobalProperties Properties    `yaml:"global_properties"`
+}
+
+// renderDirectory returns the directory generated files are rendered to. Relative paths are resolved against
+// the base directory and the default location is used unless a render directory is configured. The directory is
+// removed on cleanup, so it must be located strictly inside the base directory.
+func (config BuildConfig) renderDirectory() (string, error) {
+	directory := config.RenderDir
+	if len(directory) == 0 {
+		directory = DefaultRenderDir
+	}
+	if !filepath.IsAbs(directory) {
+		directory = filepath.Join(config.BaseDir, directory)
+	}
+	if !isInDirectory(directory, config.BaseDir) {
+		return "", fmt.Errorf("render directory %s must be located inside the project directory %s", directory, config.BaseDir)
+	}
+	return directory, nil
+}
+
+// contextPath returns the path of a file relative to the build context root. Relative paths are considered
+// to be relative to the build context root already.
+func (config BuildConfig) contextPath(file string) (string, error) {
+	if !filepath.IsAbs(file) {
+		return file, nil
+	}
+	baseDir, err := filepath.Abs(config.BaseDir)
+	if err != nil {
+		return "", err
+	}
+	relative, err := filepath.Rel(baseDir, file)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return "", fmt.Errorf("%s is located outside of the build context %s", file, baseDir)
+	}
+	return relative, nil
 }
 
 func (config BuildConfig) validate() error {
@@ -65,5 +161,177 @@ func (config *BuildConfig) LoadConfigFromFile(fileName string) error {
 		return fmt.Errorf("cannot unmarshal data: %v", err)
 	}
 
+	err = config.checkReservedProperties()
+	if err != nil {
+		return err
+	}
+	return config.interpolateProperties()
+}
+
+// reservedPropertyNames are names of values provided to templates by cake which can't be defined as properties
+var reservedPropertyNames = []string{"images", "generated_dir"}
+
+func checkReservedProperty(name string, source string) error {
+	if contains(reservedPropertyNames, name) {
+		return fmt.Errorf("property '%s' defined in %s uses a reserved name, reserved names are: %s",
+			name, source, strings.Join(reservedPropertyNames, ", "))
+	}
+	return nil
+}
+
+// checkReservedProperties fails if a global or an image property uses a reserved name
+func (config *BuildConfig) checkReservedProperties() error {
+	for name := range config.GlobalProperties {
+		if err := checkReservedProperty(name, "global properties"); err != nil {
+			return err
+		}
+	}
+	for _, image := range config.Images {
+		for name := range image.Properties {
+			if err := checkReservedProperty(name, "image "+image.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddPropertyOverride parses an assignment in the form of 'key=value' or '<image id>.key=value' and registers
+// it as a property override. The key is treated as image-specific only if its prefix matches an image ID.
+func (config *BuildConfig) AddPropertyOverride(assignment string, source string) error {
+	parts := strings.SplitN(assignment, "=", 2)
+	if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+		return fmt.Errorf("invalid property assignment '%s', expected 'key=value' or 'id.key=value'", assignment)
+	}
+	return config.addPropertyOverride(strings.TrimSpace(parts[0]), parts[1], source)
+}
+
+// LoadPropertiesFromFile reads a YAML map of property overrides from a file. Keys follow the same
+// 'key' or '<image id>.key' notation as the --set flag.
+func (config *BuildConfig) LoadPropertiesFromFile(fileName string) error {
+	propertiesFile, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("error reading properties file: %v", err)
+	}
+
+	properties := make(map[string]string)
+	err = yaml.Unmarshal(propertiesFile, &properties)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal properties file %s: %v", fileName, err)
+	}
+
+	// sorting keys to register overrides in a deterministic order
+	var keys []string
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		err = config.addPropertyOverride(key, properties[key], fileName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (config *BuildConfig) addPropertyOverride(key string, value string, source string) error {
+	override := PropertyOverride{Key: key, Value: value, Source: source}
+
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) == 2 {
+		for _, image := range config.Images {
+			if image.Id == parts[0] {
+				override.Image = parts[0]
+				override.Key = parts[1]
+				break
+			}
+		}
+	}
+
+	if err := checkReservedProperty(override.Key, source); err != nil {
+		return err
+	}
+	config.PropertyOverrides = append(config.PropertyOverrides, override)
+	return nil
+}
+
+// interpolateProperties replaces ${ENV_VAR} and ${ENV_VAR:-default} references in global and image properties
+// with the values of environment variables
+func (config *BuildConfig) interpolateProperties() error {
+	for key, value := range config.GlobalProperties {
+		interpolated, err := interpolateValue(value)
+		if err != nil {
+			return fmt.Errorf("failed to interpolate global property '%s': %v", key, err)
+		}
+		config.GlobalProperties[key] = interpolated
+	}
+
+	for _, image := range config.Images {
+		for key, value := range image.Properties {
+			interpolated, err := interpolateValue(value)
+			if err != nil {
+				return fmt.Errorf("failed to interpolate property '%s' of image %s: %v", key, image.Id, err)
+			}
+			image.Properties[key] = interpolated
+		}
+	}
 	return nil
 }
+
+// interpolateValue substitutes environment variable references in all string values of a (possibly structured)
+// property value
+func interpolateValue(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return interpolateEnv(typed)
+	case []interface{}:
+		for i, item := range typed {
+			interpolated, err := interpolateValue(item)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = interpolated
+		}
+	case map[string]interface{}:
+		for key, item := range typed {
+			interpolated, err := interpolateValue(item)
+			if err != nil {
+				return nil, err
+			}
+			typed[key] = interpolated
+		}
+	}
+	return value, nil
+}
+
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv substitutes environment variable references in the value. Similar to shell, the default value is
+// used when the variable is unset or empty, and '$$' produces a literal '$'. A reference to an unset variable
+// without a default value is an error.
+func interpolateEnv(value string) (string, error) {
+	var err error
+	interpolated := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		if reference == "$$" {
+			return "$"
+		}
+		groups := envReference.FindStringSubmatch(reference)
+		envValue, found := os.LookupEnv(groups[1])
+		if len(envValue) > 0 {
+			return envValue
+		}
+		if len(groups[2]) > 0 {
+			return groups[3]
+		}
+		if found {
+			return envValue
+		}
+		if err == nil {
+			err = fmt.Errorf("environment variable %s is not set", groups[1])
+		}
+		return reference
+	})
+	return interpolated, err
+}
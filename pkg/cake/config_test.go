@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,6 +28,16 @@ images:
     extra_files:
       - base_file_1
       - base_file_2
+    build_args:
+      HTTP_PROXY: http://proxy:3128
+    target: runtime
+    labels:
+      team: data
+    platform: linux/arm64
+    network_mode: host
+    no_cache: true
+    pull: true
+    squash: true
 
   - id: child
     parent: base
@@ -99,6 +110,16 @@ images:
 	assert.Contains(t, base.ExtraFiles, "base_file_1")
 	assert.Contains(t, base.ExtraFiles, "base_file_2")
 
+	assert.Equal(t, map[string]string{"HTTP_PROXY": "http://proxy:3128"}, base.BuildArgs)
+	assert.Equal(t, "runtime", base.Target)
+	assert.Equal(t, map[string]string{"team": "data"}, base.Labels)
+	assert.Equal(t, "linux/arm64", base.Platform)
+	assert.Equal(t, "host", base.NetworkMode)
+	assert.True(t, base.NoCache)
+	assert.True(t, base.Pull)
+	assert.True(t, base.Squash)
+	assert.False(t, child.NoCache)
+
 	assert.Equal(t, "testorg", child.Repository)
 	assert.Equal(t, "test", child.Name)
 	assert.Equal(t, "child/Dockerfile.template", child.Template)
@@ -113,3 +134,167 @@ images:
 	assert.Contains(t, child.ExtraFiles, "child_file_1")
 	assert.Contains(t, child.ExtraFiles, "child_file_2")
 }
+
+func TestAddPropertyOverride(t *testing.T) {
+	buildConfig := BuildConfig{
+		Images: []ImageConfig{
+			{Id: "base"},
+			{Id: "child", Parent: "base"},
+		},
+	}
+
+	assert.Nil(t, buildConfig.AddPropertyOverride("version=1.0.0", "--set"))
+	assert.Nil(t, buildConfig.AddPropertyOverride("child.version=2.0.0", "--set"))
+	assert.Nil(t, buildConfig.AddPropertyOverride("spark.version=2.4.5", "--set"))
+	assert.Nil(t, buildConfig.AddPropertyOverride("url=http://host?a=b", "--set"))
+
+	expected := []PropertyOverride{
+		{Key: "version", Value: "1.0.0", Source: "--set"},
+		{Image: "child", Key: "version", Value: "2.0.0", Source: "--set"},
+		{Key: "spark.version", Value: "2.4.5", Source: "--set"},
+		{Key: "url", Value: "http://host?a=b", Source: "--set"},
+	}
+	assert.Equal(t, expected, buildConfig.PropertyOverrides)
+
+	assert.NotNil(t, buildConfig.AddPropertyOverride("version", "--set"))
+	assert.NotNil(t, buildConfig.AddPropertyOverride("=value", "--set"))
+}
+
+func TestLoadPropertiesFromFile(t *testing.T) {
+	properties := `version: 1.10
+child.spark_version: 3.0.0
+empty:
+`
+	tmpFile, err := ioutil.TempFile("", "properties")
+	if err != nil {
+		t.Errorf("Failed to create file: %v", err)
+	}
+	_, err = tmpFile.Write([]byte(properties))
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	buildConfig := BuildConfig{
+		Images: []ImageConfig{{Id: "child"}},
+	}
+	err = buildConfig.LoadPropertiesFromFile(tmpFile.Name())
+	if err != nil {
+		t.Errorf("Failed to load properties file: %v", err)
+	}
+
+	expected := []PropertyOverride{
+		{Image: "child", Key: "spark_version", Value: "3.0.0", Source: tmpFile.Name()},
+		{Key: "empty", Value: "", Source: tmpFile.Name()},
+		{Key: "version", Value: "1.10", Source: tmpFile.Name()},
+	}
+	assert.Equal(t, expected, buildConfig.PropertyOverrides)
+}
+
+func TestInterpolateEnv(t *testing.T) {
+	os.Setenv("CAKE_TEST_VERSION", "1.2.3")
+	os.Setenv("CAKE_TEST_EMPTY", "")
+	os.Unsetenv("CAKE_TEST_UNSET")
+	defer os.Unsetenv("CAKE_TEST_VERSION")
+	defer os.Unsetenv("CAKE_TEST_EMPTY")
+
+	cases := map[string]string{
+		"${CAKE_TEST_VERSION}":                     "1.2.3",
+		"v${CAKE_TEST_VERSION}-rc":                 "v1.2.3-rc",
+		"${CAKE_TEST_UNSET:-default}":              "default",
+		"${CAKE_TEST_EMPTY:-default}":              "default",
+		"${CAKE_TEST_EMPTY}":                       "",
+		"${CAKE_TEST_VERSION:-default}":            "1.2.3",
+		"$${CAKE_TEST_VERSION}":                    "${CAKE_TEST_VERSION}",
+		"plain $HOME value":                        "plain $HOME value",
+		"${CAKE_TEST_UNSET:-}${CAKE_TEST_VERSION}": "1.2.3",
+	}
+
+	for value, expected := range cases {
+		interpolated, err := interpolateEnv(value)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, interpolated)
+	}
+
+	_, err := interpolateEnv("${CAKE_TEST_UNSET}")
+	assert.EqualError(t, err, "environment variable CAKE_TEST_UNSET is not set")
+}
+
+func TestLoadStructuredProperties(t *testing.T) {
+	config := `global_properties:
+  version: 10.10
+  empty:
+  pip_packages:
+    - numpy==1.18.1
+    - pandas
+  settings:
+    java:
+      version: 8
+      home: /opt/java
+
+images:
+  - id: base
+    repository: testorg
+    name: test
+    template: base/Dockerfile.template
+    properties:
+      ports: [8080, 8081]
+`
+	tmpFile, err := ioutil.TempFile("", "cake.yaml")
+	if err != nil {
+		t.Errorf("Failed to create file: %v", err)
+	}
+	_, err = tmpFile.Write([]byte(config))
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	var buildConfig BuildConfig
+	err = buildConfig.LoadConfigFromFile(tmpFile.Name())
+	if err != nil {
+		t.Errorf("Failed to unmarshall file: %v", err)
+	}
+
+	assert.Equal(t, "10.10", buildConfig.GlobalProperties["version"])
+	assert.Equal(t, "", buildConfig.GlobalProperties["empty"])
+	assert.Equal(t, []interface{}{"numpy==1.18.1", "pandas"}, buildConfig.GlobalProperties["pip_packages"])
+	assert.Equal(t, map[string]interface{}{
+		"java": map[string]interface{}{
+			"version": "8",
+			"home":    "/opt/java",
+		},
+	}, buildConfig.GlobalProperties["settings"])
+	assert.Equal(t, []interface{}{"8080", "8081"}, buildConfig.Images[0].Properties["ports"])
+}
+
+func TestReservedPropertyNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := path.Join(dir, "cake.yaml")
+	for _, content := range []string{
+		"global_properties:\n  images: foo\n",
+		"images:\n  - id: base\n    properties:\n      generated_dir: foo\n",
+	} {
+		err = ioutil.WriteFile(configFile, []byte(content), 0644)
+		if err != nil {
+			t.Errorf("Failed to write file: %v", err)
+		}
+		var config BuildConfig
+		err = config.LoadConfigFromFile(configFile)
+		if err == nil || !strings.Contains(err.Error(), "reserved name") {
+			t.Errorf("Expected an error for a reserved property name in:\n%s\nFound: %v", content, err)
+		}
+	}
+
+	var config BuildConfig
+	err = config.AddPropertyOverride("images=foo", "--set")
+	if err == nil {
+		t.Errorf("Expected an error for overriding a reserved property")
+	}
+	if len(config.PropertyOverrides) > 0 {
+		t.Errorf("Expected the reserved property override not to be registered")
+	}
+}
@@ -0,0 +1,162 @@
+package cake
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubServer is the key Docker uses for Docker Hub credentials in config.json and credential helpers
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// DockerConfigFile holds the credentials part of the Docker CLI config (~/.docker/config.json)
+type DockerConfigFile struct {
+	Auths       map[string]DockerAuthEntry `json:"auths"`
+	CredsStore  string                     `json:"credsStore"`
+	CredHelpers map[string]string          `json:"credHelpers"`
+}
+
+// DockerAuthEntry is a credentials entry of config.json, 'auth' is the base64 encoded 'username:password'
+type DockerAuthEntry struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// credentialHelperOutput is the response of 'docker-credential-<helper> get'
+type credentialHelperOutput struct {
+	Username string `json:"Username"`
+	Secret   string `json:"Secret"`
+}
+
+// dockerConfigDir returns the directory of the Docker CLI config: DOCKER_CONFIG if set, ~/.docker otherwise
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); len(dir) > 0 {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// LoadDockerConfig reads config.json from the Docker config directory, a missing file results in an empty config
+func LoadDockerConfig() (*DockerConfigFile, error) {
+	config := &DockerConfigFile{}
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return config, nil
+	}
+	fileName := filepath.Join(dir, "config.json")
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("error reading Docker config: %v", err)
+	}
+	err = json.Unmarshal(content, config)
+	if err != nil {
+		return config, fmt.Errorf("cannot parse Docker config %s: %v", fileName, err)
+	}
+	return config, nil
+}
+
+// registryHost returns the host of a registry URL, Docker Hub hosts are normalized to 'index.docker.io'
+func registryHost(url string) string {
+	host := url
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "index.docker.io"
+	}
+	return host
+}
+
+// Credentials returns the username and the password for the registry. Credential helpers configured for the registry
+// host take precedence over the default credentials store, which takes precedence over credentials stored in auths.
+// Empty values are returned if there are no credentials for the registry.
+func (config *DockerConfigFile) Credentials(registryUrl string) (string, string, error) {
+	host := registryHost(registryUrl)
+	server := host
+	if host == "index.docker.io" {
+		server = dockerHubServer
+	}
+
+	if helper, found := config.CredHelpers[server]; found {
+		return helperCredentials(helper, server)
+	}
+	if len(config.CredsStore) > 0 {
+		username, password, err := helperCredentials(config.CredsStore, server)
+		if err != nil || len(username) > 0 || len(password) > 0 {
+			return username, password, err
+		}
+	}
+
+	for key, entry := range config.Auths {
+		if registryHost(key) != host {
+			continue
+		}
+		if len(entry.Auth) == 0 {
+			return entry.Username, entry.Password, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid auth of %s in Docker config: %v", key, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("invalid auth of %s in Docker config, expected 'username:password'", key)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", nil
+}
+
+// helperCredentials runs 'docker-credential-<helper> get' with the server URL as input. Credentials which
+// are not found in the helper result in empty values.
+func helperCredentials(helper string, server string) (string, string, error) {
+	command := exec.Command("docker-credential-"+helper, "get")
+	command.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	out, err := command.Output()
+	if err != nil {
+		message := strings.TrimSpace(string(out) + stderr.String())
+		if strings.Contains(message, "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("credential helper %s failed for %s: %v %s", helper, server, err, message)
+	}
+
+	var credentials credentialHelperOutput
+	err = json.Unmarshal(out, &credentials)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot parse output of credential helper %s: %v", helper, err)
+	}
+	return credentials.Username, credentials.Secret, nil
+}
+
+// ResolveCredentials fills in the username and the password of the registry from the Docker config unless they
+// are set explicitly, e.g. via flags
+func ResolveCredentials(authConfig AuthConfig) (AuthConfig, error) {
+	if len(authConfig.Username) > 0 || len(authConfig.Password) > 0 {
+		return authConfig, nil
+	}
+	dockerConfig, err := LoadDockerConfig()
+	if err != nil {
+		return authConfig, err
+	}
+	authConfig.Username, authConfig.Password, err = dockerConfig.Credentials(authConfig.DockerRegistryUrl)
+	return authConfig, err
+}
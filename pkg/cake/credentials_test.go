@@ -0,0 +1,120 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerConfigCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper scripts require a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "docker-config")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the helper stores credentials for quay.io only and reports other servers as not found
+	helper := `#!/bin/sh
+read server
+if [ "$server" = "quay.io" ]; then
+  echo '{"ServerURL": "quay.io", "Username": "robot", "Secret": "helper-secret"}'
+else
+  echo "credentials not found in native keychain"
+  exit 1
+fi
+`
+	err = ioutil.WriteFile(path.Join(dir, "docker-credential-test"), []byte(helper), 0755)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	config := `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "dXNlcjpodWItc2VjcmV0"},
+    "localhost:5000": {"auth": "bG9jYWw6bG9jYWwtc2VjcmV0"}
+  },
+  "credHelpers": {"quay.io": "test"},
+  "credsStore": "test"
+}`
+	err = ioutil.WriteFile(path.Join(dir, "config.json"), []byte(config), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	defer os.Setenv("DOCKER_CONFIG", os.Getenv("DOCKER_CONFIG"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	os.Setenv("DOCKER_CONFIG", dir)
+
+	authConfig, err := ResolveCredentials(AuthConfig{DockerRegistryUrl: "https://quay.io"})
+	if err != nil {
+		t.Errorf("Unexpected error while resolving credentials: %v", err)
+	}
+	assert.Equal(t, AuthConfig{DockerRegistryUrl: "https://quay.io", Username: "robot", Password: "helper-secret"}, authConfig)
+
+	// credentials not found in the credentials store are taken from auths
+	authConfig, err = ResolveCredentials(AuthConfig{DockerRegistryUrl: "https://index.docker.io"})
+	if err != nil {
+		t.Errorf("Unexpected error while resolving credentials: %v", err)
+	}
+	assert.Equal(t, "user", authConfig.Username)
+	assert.Equal(t, "hub-secret", authConfig.Password)
+
+	authConfig, err = ResolveCredentials(AuthConfig{DockerRegistryUrl: "http://localhost:5000"})
+	if err != nil {
+		t.Errorf("Unexpected error while resolving credentials: %v", err)
+	}
+	assert.Equal(t, "local", authConfig.Username)
+	assert.Equal(t, "local-secret", authConfig.Password)
+
+	// explicit credentials take precedence over the Docker config
+	explicit := AuthConfig{DockerRegistryUrl: "https://quay.io", Username: "flag-user", Password: "flag-secret"}
+	authConfig, err = ResolveCredentials(explicit)
+	if err != nil {
+		t.Errorf("Unexpected error while resolving credentials: %v", err)
+	}
+	assert.Equal(t, explicit, authConfig)
+
+	authConfig, err = ResolveCredentials(AuthConfig{DockerRegistryUrl: "https://example.com"})
+	if err != nil {
+		t.Errorf("Unexpected error while resolving credentials: %v", err)
+	}
+	assert.Empty(t, authConfig.Username)
+
+	// credentials are resolved only for registries used by images, Docker Hub is not used
+	dockerfile := path.Join(dir, "Dockerfile")
+	err = ioutil.WriteFile(dockerfile, []byte("FROM localhost:5000/base:1.0 AS builder\nFROM builder\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	graph := &Image{ImageConfig: ImageConfig{Id: "app", Repository: "quay.io/org", Name: "app"}, Dockerfile: dockerfile}
+	auths, err := ResolveRegistryAuths(explicit, graph)
+	if err != nil {
+		t.Errorf("Unexpected error while resolving registry credentials: %v", err)
+	}
+	expected := map[string]AuthConfig{
+		"quay.io":        explicit,
+		"localhost:5000": {DockerRegistryUrl: "localhost:5000", Username: "local", Password: "local-secret"},
+	}
+	assert.Equal(t, expected, auths)
+
+	// credentials of the configured registry are read from the Docker config if images without a host are pushed to it
+	graph.ImageConfig.Repository = "org"
+	hub := AuthConfig{DockerRegistryUrl: "https://index.docker.io"}
+	auths, err = ResolveRegistryAuths(hub, graph)
+	if err != nil {
+		t.Errorf("Unexpected error while resolving registry credentials: %v", err)
+	}
+	expected = map[string]AuthConfig{
+		"index.docker.io": {DockerRegistryUrl: "https://index.docker.io", Username: "user", Password: "hub-secret"},
+		"localhost:5000":  {DockerRegistryUrl: "localhost:5000", Username: "local", Password: "local-secret"},
+	}
+	assert.Equal(t, expected, auths)
+	assert.Equal(t, expected["index.docker.io"], BuildConfig{AuthConfig: hub, RegistryAuths: auths}.registryAuthConfig("org/app"))
+}
@@ -8,8 +8,15 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
@@ -19,6 +26,7 @@ import (
 
 type DockerClient interface {
 	Tags(imageName string) (tags []string, err error)
+	ManifestDigest(imageName string, tag string) (string, error)
 	ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
 	ImagePush(ctx context.Context, image string, options types.ImagePushOptions) (io.ReadCloser, error)
 }
@@ -26,8 +34,12 @@ type DockerClient interface {
 type ExternalDockerClient struct {
 	AuthConfig AuthConfig
 	Client     *client.Client
-	Registry   *registry.Registry
-	TagsCache  map[string][]string
+	// client of the configured registry, created on first use
+	Registry  *registry.Registry
+	TagsCache map[string][]string
+	// clients of other registries by host
+	registries     map[string]*registry.Registry
+	registriesLock sync.Mutex
 }
 
 // Tags retrieves tags list from the registry for specified image name and adds them to the cache.
@@ -40,7 +52,11 @@ func (client *ExternalDockerClient) Tags(imageName string) (tags []string, err e
 		return tagsCached, nil
 	}
 
-	imageTags, err := client.Registry.Tags(imageName)
+	dockerRegistry, repository, err := client.RegistryFor(imageName)
+	if err != nil {
+		return nil, err
+	}
+	imageTags, err := dockerRegistry.Tags(repository)
 	if err == nil {
 		// add received tags to the cache
 		log.Printf("Caching received tags for '%s' image", imageName)
@@ -49,6 +65,35 @@ func (client *ExternalDockerClient) Tags(imageName string) (tags []string, err e
 	return imageTags, err
 }
 
+// ManifestDigest retrieves the digest of the image manifest from the registry. Schema 2 manifests and manifest lists
+// are requested explicitly to get the same digest Docker daemon reports after a push or pull.
+func (client *ExternalDockerClient) ManifestDigest(imageName string, tag string) (string, error) {
+	dockerRegistry, repository, err := client.RegistryFor(imageName)
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", strings.TrimSuffix(dockerRegistry.URL, "/"), repository, tag)
+	request, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, mediaType := range []string{schema2.MediaTypeManifest, manifestlist.MediaTypeManifestList, ociManifestMediaType, ociIndexMediaType} {
+		request.Header.Add("Accept", mediaType)
+	}
+
+	response, err := dockerRegistry.Client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	digest := response.Header.Get("Docker-Content-Digest")
+	if len(digest) == 0 {
+		return "", fmt.Errorf("registry returned no digest for %s:%s", imageName, tag)
+	}
+	return digest, nil
+}
+
 func (client *ExternalDockerClient) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
 	return client.Client.ImageBuild(context.Background(), buildContext, options)
 }
@@ -61,21 +106,34 @@ func NewExternalDockerClient(authConfig AuthConfig) *ExternalDockerClient {
 	dockerClient := ExternalDockerClient{
 		AuthConfig: authConfig,
 		TagsCache:  make(map[string][]string),
+		registries: make(map[string]*registry.Registry),
 	}
 
-	dockerRegistry, err := registry.New(authConfig.DockerRegistryUrl, authConfig.Username, authConfig.Password)
-	if err != nil {
-		log.Fatal(err)
-	}
+	dockerClient.Client = NewDaemonClient()
+	return &dockerClient
+}
 
+// NewDaemonClient creates a client of the Docker daemon configured with the environment variables
+func NewDaemonClient() *client.Client {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithVersion("1.39"))
 	if err != nil {
 		log.Fatal(err)
 	}
+	return cli
+}
 
-	dockerClient.Client = cli
-	dockerClient.Registry = dockerRegistry
-	return &dockerClient
+// ResolveDigest reads the manifest digest of an image which wasn't pushed by this build from the registry
+func ResolveDigest(dockerClient DockerClient, image *Image, config BuildConfig) error {
+	if len(image.Digest) > 0 {
+		return nil
+	}
+	digest, err := dockerClient.ManifestDigest(image.getFullName(), image.getStableTag(config))
+	if err != nil {
+		return fmt.Errorf("unable to retrieve digest of %s: %v", image.StableReference(config), err)
+	}
+	log.Printf("Found image %s with digest: %s", image.StableReference(config), digest)
+	image.Digest = digest
+	return nil
 }
 
 func ImageExists(dockerClient DockerClient, image *Image, config BuildConfig) (bool, error) {
@@ -103,7 +161,9 @@ func BuildImage(dockerClient DockerClient, image *Image, config BuildConfig) err
 		return err
 	}
 
-	buildContextTarName := fmt.Sprintf("%s/%s_%s_context.tar", tmpDir, imageConfig.Repository, imageConfig.Name)
+	// repositories may contain a registry host and an organization separated by slashes
+	repository := strings.Replace(imageConfig.Repository, "/", "_", -1)
+	buildContextTarName := fmt.Sprintf("%s/%s_%s_context.tar", tmpDir, repository, imageConfig.Name)
 
 	err = Tar(config.BaseDir, buildContextTarName)
 	if err != nil {
@@ -117,20 +177,55 @@ func BuildImage(dockerClient DockerClient, image *Image, config BuildConfig) err
 	defer dockerBuildContext.Close()
 
 	log.Printf("Building image with tags: %s", image.getDockerTags(config))
-	base64Auth, err := base64Auth(config)
+
+	// credentials of the configured registry take precedence over credentials of other registries,
+	// e.g. hosting private base images
+	authConfigs := make(map[string]types.AuthConfig)
+	for _, authConfig := range append([]AuthConfig{config.configuredRegistryAuthConfig()}, sortedRegistryAuths(config.RegistryAuths)...) {
+		if _, found := authConfigs[authConfig.serverAddress()]; !found {
+			authConfigs[authConfig.serverAddress()] = types.AuthConfig{
+				Username:      authConfig.Username,
+				Password:      authConfig.Password,
+				ServerAddress: authConfig.serverAddress(),
+			}
+		}
+	}
+
+	// Dockerfile location must be relative to the build context root
+	dockerfile, err := config.contextPath(image.Dockerfile)
 	if err != nil {
 		return err
 	}
 
-	authConfigs := make(map[string]types.AuthConfig)
-	authConfigs[config.AuthConfig.DockerRegistryUrl] = types.AuthConfig{
-		Auth: base64Auth,
+	resources, err := ResolveResources(image, config)
+	if err != nil {
+		return err
+	}
+
+	buildArgs := make(map[string]*string)
+	for name, value := range image.BuildArgs {
+		value := value
+		buildArgs[name] = &value
 	}
 
 	options := types.ImageBuildOptions{
-		Dockerfile:  image.Dockerfile,
+		Dockerfile:  dockerfile,
 		Tags:        image.getDockerTags(config),
 		AuthConfigs: authConfigs,
+		BuildArgs:   buildArgs,
+		Target:      image.ImageConfig.Target,
+		Labels:      image.imageLabels(config),
+		Platform:    image.ImageConfig.Platform,
+		NetworkMode: image.ImageConfig.NetworkMode,
+		NoCache:     image.ImageConfig.NoCache,
+		PullParent:  image.ImageConfig.Pull,
+		Squash:      image.ImageConfig.Squash,
+		Memory:      resources.Memory,
+		MemorySwap:  resources.MemorySwap,
+		CPUShares:   resources.CpuShares,
+		CPUQuota:    resources.CpuQuota,
+		ShmSize:     resources.ShmSize,
+		Ulimits:     resources.Ulimits,
 	}
 
 	response, err := dockerClient.ImageBuild(context.Background(), dockerBuildContext, options)
@@ -138,16 +233,43 @@ func BuildImage(dockerClient DockerClient, image *Image, config BuildConfig) err
 		return err
 	}
 
-	err = handleOutput(response.Body)
+	tracker := &stepTracker{writer: os.Stderr}
+	err = handleOutputTo(response.Body, tracker, nil)
 	if err != nil {
-		return err
+		return image.buildError(tracker.step, err)
 	}
 
 	return nil
 }
 
+// buildError reports the template line of the instruction which failed at the build step if it's known
+func (image *Image) buildError(step int, err error) error {
+	if step == 0 {
+		return fmt.Errorf("failed to build image %s: %v", image.ImageConfig.Id, err)
+	}
+	if file, line, found := image.TemplateLocation(step); found {
+		return fmt.Errorf("failed to build image %s at %s:%d (step %d): %v", image.ImageConfig.Id, file, line, step, err)
+	}
+	return fmt.Errorf("failed to build image %s at step %d of %s: %v", image.ImageConfig.Id, step, image.Dockerfile, err)
+}
+
+var buildStepPattern = regexp.MustCompile(`Step ([0-9]+)/[0-9]+ :`)
+
+// stepTracker passes the build output through and keeps the number of the last started build step
+type stepTracker struct {
+	writer io.Writer
+	step   int
+}
+
+func (tracker *stepTracker) Write(data []byte) (int, error) {
+	for _, match := range buildStepPattern.FindAllSubmatch(data, -1) {
+		tracker.step, _ = strconv.Atoi(string(match[1]))
+	}
+	return tracker.writer.Write(data)
+}
+
 func PushImage(dockerClient DockerClient, image *Image, config BuildConfig) error {
-	base64Auth, err := base64Auth(config)
+	base64Auth, err := encodeAuth(config.registryAuthConfig(image.getFullName()))
 	if err != nil {
 		return err
 	}
@@ -164,19 +286,44 @@ func PushImage(dockerClient DockerClient, image *Image, config BuildConfig) erro
 			return fmt.Errorf("error while pushing image %s: %v", tag, err)
 		}
 
-		err = handleOutput(out)
+		// the manifest digest is the same for all the tags and is reported in the aux message of the push stream
+		err = handleOutput(out, func(aux *json.RawMessage) {
+			var result pushResult
+			if err := json.Unmarshal(*aux, &result); err == nil && len(result.Digest) > 0 {
+				image.Digest = result.Digest
+			}
+		})
 		if err != nil {
 			return err
 		}
 	}
 
+	if len(image.Digest) > 0 {
+		log.Printf("Pushed image %s with digest: %s", image.getFullName(), image.Digest)
+	}
 	return nil
 }
 
-func handleOutput(reader io.ReadCloser) error {
+// pushResult is the aux message sent by Docker daemon after pushing an image
+type pushResult struct {
+	Tag    string
+	Digest string
+	Size   int
+}
+
+// handleOutput displays the JSON messages stream returned by Docker daemon and passes aux messages to the handler
+func handleOutput(reader io.ReadCloser, auxHandler func(aux *json.RawMessage)) error {
+	return handleOutputTo(reader, os.Stderr, auxHandler)
+}
+
+// handleOutputTo displays the JSON messages stream using the writer
+func handleOutputTo(reader io.ReadCloser, writer io.Writer, auxHandler func(aux *json.RawMessage)) error {
 	termFd, isTerm := term.GetFdInfo(os.Stderr)
-	err := jsonmessage.DisplayJSONMessagesStream(reader, os.Stderr, termFd, isTerm, func(message jsonmessage.JSONMessage) {
+	err := jsonmessage.DisplayJSONMessagesStream(reader, writer, termFd, isTerm, func(message jsonmessage.JSONMessage) {
 		log.Println(string(*message.Aux))
+		if auxHandler != nil {
+			auxHandler(message.Aux)
+		}
 	})
 	if err != nil {
 		return fmt.Errorf("error response from Docker daemon: %v", err)
@@ -186,10 +333,10 @@ func handleOutput(reader io.ReadCloser) error {
 	return nil
 }
 
-func base64Auth(config BuildConfig) (string, error) {
+func encodeAuth(auth AuthConfig) (string, error) {
 	authConfig := types.AuthConfig{
-		Username: config.AuthConfig.Username,
-		Password: config.AuthConfig.Password,
+		Username: auth.Username,
+		Password: auth.Password,
 	}
 	encodedJSON, err := json.Marshal(authConfig)
 	if err != nil {
@@ -20,16 +20,33 @@ type MockDockerClient struct {
 	ImageBuildOptions      types.ImageBuildOptions
 	ImagePushOptions       types.ImagePushOptions
 	ImagePushTags          []string
+	MockPushDigest         string
+	MockBuildOutput        string
+	MockManifestDigest     string
+	MockTagsByImage        map[string][]string
+	ManifestRequests       []string
 }
 
 func (client *MockDockerClient) Tags(imageName string) (tags []string, err error) {
+	if tags, found := client.MockTagsByImage[imageName]; found {
+		return tags, nil
+	}
 	return client.MockTagsResponse, nil
 }
 
+func (client *MockDockerClient) ManifestDigest(imageName string, tag string) (string, error) {
+	client.ManifestRequests = append(client.ManifestRequests, imageName+":"+tag)
+	return client.MockManifestDigest, nil
+}
+
 func (client *MockDockerClient) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
 	client.ImageBuildOptions = options
+	output := `{"message": "image built"}`
+	if len(client.MockBuildOutput) > 0 {
+		output = client.MockBuildOutput
+	}
 	response := types.ImageBuildResponse{
-		Body: ioutil.NopCloser(strings.NewReader(`{"message": "image built"}`)),
+		Body: ioutil.NopCloser(strings.NewReader(output)),
 	}
 	//setting input options here to verify them in test
 	client.ImageBuildOptions = options
@@ -40,6 +57,10 @@ func (client *MockDockerClient) ImagePush(ctx context.Context, image string, opt
 	client.ImagePushOptions = options
 	//this method called multiple times so we need to collect all the pushed tags
 	client.ImagePushTags = append(client.ImagePushTags, image)
+	if client.MockPushDigest != "" {
+		aux := `{"aux": {"Tag": "latest", "Digest": "` + client.MockPushDigest + `", "Size": 1024}}`
+		return ioutil.NopCloser(strings.NewReader(aux)), nil
+	}
 	return ioutil.NopCloser(strings.NewReader(`{"message": "image pushed"}`)), nil
 }
 
@@ -94,14 +115,23 @@ func TestImageBuild(t *testing.T) {
 	}
 
 	imageConfig := ImageConfig{
-		Repository: "repository",
-		Name:       "image-name",
+		Repository:  "repository",
+		Name:        "image-name",
+		Target:      "runtime",
+		Labels:      map[string]string{"team": "data"},
+		Platform:    "linux/amd64",
+		NetworkMode: "host",
+		NoCache:     true,
+		Pull:        true,
+		Squash:      true,
+		Resources:   ResourcesConfig{Memory: "2g", ShmSize: "512m"},
 	}
 
 	image := Image{
 		Dockerfile:  "base/Dockerfile",
 		ImageConfig: imageConfig,
 		Checksum:    "12w21ew",
+		BuildArgs:   map[string]string{"PARENT_IMAGE": "repository/parent:1.0"},
 	}
 
 	expectedTags := []string{
@@ -123,6 +153,21 @@ func TestImageBuild(t *testing.T) {
 		t.Errorf("Expected Dockerfile %s but found %s in ImageBuildOptions", image.Dockerfile, buildOptions.Dockerfile)
 	}
 
+	parentArg, found := buildOptions.BuildArgs["PARENT_IMAGE"]
+	if !found || parentArg == nil || *parentArg != "repository/parent:1.0" {
+		t.Errorf("Expected parent image to be passed in ImageBuildOptions.BuildArgs but found: %v", buildOptions.BuildArgs)
+	}
+
+	if buildOptions.Target != "runtime" || buildOptions.Platform != "linux/amd64" || buildOptions.NetworkMode != "host" ||
+		!buildOptions.NoCache || !buildOptions.PullParent || !buildOptions.Squash ||
+		buildOptions.Labels["team"] != "data" {
+		t.Errorf("Build settings of the image are not passed to ImageBuildOptions: %+v", buildOptions)
+	}
+
+	if buildOptions.Memory != 2*1024*1024*1024 || buildOptions.ShmSize != 512*1024*1024 {
+		t.Errorf("Resources of the image are not passed to ImageBuildOptions: memory %d, shm size %d", buildOptions.Memory, buildOptions.ShmSize)
+	}
+
 	sort.Strings(buildOptions.Tags)
 	if !reflect.DeepEqual(expectedTags, buildOptions.Tags) {
 		t.Errorf("Tags in ImageBuildOptions differ from the expected.\nExpected:\n%s\nFound:\n%s", expectedTags, buildOptions.Tags)
@@ -132,19 +177,16 @@ func TestImageBuild(t *testing.T) {
 		t.Errorf("Expected single entry in AuthConfigs but found: %s", buildOptions.AuthConfigs)
 	}
 
-	authConfig, found := buildOptions.AuthConfigs[buildConfig.AuthConfig.DockerRegistryUrl]
+	//Docker Hub credentials are stored under the server address used by Docker
+	authConfig, found := buildOptions.AuthConfigs[dockerHubServer]
 
 	if !found {
-		t.Errorf("Expected config is not present in AuthConfigs for registry: %s", buildConfig.AuthConfig.DockerRegistryUrl)
+		t.Errorf("Expected config is not present in AuthConfigs for registry: %s", dockerHubServer)
 	}
 
-	base64Auth, err := base64Auth(buildConfig)
-	if err != nil {
-		t.Error(err)
-	}
-
-	if authConfig.Auth != base64Auth {
-		t.Errorf("Base64 Auth differs from expected in ImageBuildOptions.AuthConfigs:\nExpected:\n%s\nFound:\n%s", base64Auth, authConfig.Auth)
+	expectedAuthConfig := types.AuthConfig{Username: "user", Password: "password", ServerAddress: dockerHubServer}
+	if authConfig != expectedAuthConfig {
+		t.Errorf("Auth differs from expected in ImageBuildOptions.AuthConfigs:\nExpected:\n%+v\nFound:\n%+v", expectedAuthConfig, authConfig)
 	}
 }
 
@@ -192,7 +234,7 @@ func TestPushImage(t *testing.T) {
 
 	pushOptions := dockerClient.ImagePushOptions
 
-	base64Auth, err := base64Auth(buildConfig)
+	base64Auth, err := encodeAuth(buildConfig.AuthConfig)
 	if err != nil {
 		t.Error(err)
 	}
@@ -206,6 +248,89 @@ func TestPushImage(t *testing.T) {
 	}
 }
 
+func TestImageBuildErrorLocation(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "base")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	templateFile := path.Join(baseDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte("FROM ubuntu\n\nENV A b\n{{! comment }}\nRUN {{command}}\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	config := BuildConfig{BaseDir: baseDir}
+	image := Image{ImageConfig: ImageConfig{Id: "child", Template: templateFile, Properties: Properties{"command": "false"}}}
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	dockerClient := new(MockDockerClient)
+	dockerClient.MockBuildOutput = `{"stream": "Step 1/3 : FROM ubuntu\n"}
+{"stream": "Step 2/3 : ENV A b\n"}
+{"stream": "Step 3/3 : RUN false\n"}
+{"errorDetail": {"code": 1, "message": "The command '/bin/sh -c false' returned a non-zero code: 1"}, "error": "The command '/bin/sh -c false' returned a non-zero code: 1"}`
+
+	err = BuildImage(dockerClient, &image, config)
+	if err == nil {
+		t.Errorf("Expected build to fail")
+	}
+
+	expectedError := "failed to build image child at " + templateFile + ":5 (step 3): error response from Docker daemon: " +
+		"The command '/bin/sh -c false' returned a non-zero code: 1"
+	if err.Error() != expectedError {
+		t.Errorf("Build error differs from the expected.\nExpected:\n%s\nFound:\n%s", expectedError, err)
+	}
+}
+
+func TestPushImageRecordsDigest(t *testing.T) {
+	image := Image{
+		ImageConfig: ImageConfig{Repository: "repository", Name: "image-name"},
+		Checksum:    "12w21ew",
+	}
+
+	dockerClient := new(MockDockerClient)
+	dockerClient.MockPushDigest = "sha256:0123456789abcdef"
+	err := PushImage(dockerClient, &image, BuildConfig{ReleaseTag: "1.0"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if image.Digest != dockerClient.MockPushDigest {
+		t.Errorf("Image digest differs from the expected.\nExpected:\n%s\nFound:\n%s", dockerClient.MockPushDigest, image.Digest)
+	}
+}
+
+func TestResolveDigest(t *testing.T) {
+	image := Image{
+		ImageConfig: ImageConfig{Repository: "repository", Name: "image-name"},
+		Checksum:    "12w21ew",
+	}
+
+	dockerClient := new(MockDockerClient)
+	dockerClient.MockManifestDigest = "sha256:0123456789abcdef"
+	err := ResolveDigest(dockerClient, &image, BuildConfig{})
+	if err != nil {
+		t.Error(err)
+	}
+	if image.Digest != dockerClient.MockManifestDigest {
+		t.Errorf("Image digest differs from the expected.\nExpected:\n%s\nFound:\n%s", dockerClient.MockManifestDigest, image.Digest)
+	}
+
+	//digests reported by the push are not replaced
+	dockerClient.MockManifestDigest = "sha256:fedcba9876543210"
+	err = ResolveDigest(dockerClient, &image, BuildConfig{})
+	if err != nil {
+		t.Error(err)
+	}
+	if image.Digest != "sha256:0123456789abcdef" {
+		t.Errorf("Expected the known digest to be kept but found: %s", image.Digest)
+	}
+}
+
 func TestBase64Auth(t *testing.T) {
 	buildConfig := BuildConfig{
 		AuthConfig: AuthConfig{
@@ -217,7 +342,7 @@ func TestBase64Auth(t *testing.T) {
 	//Base64 for the following JSON: {"username":"user","password":"password"}
 	base64 := "eyJ1c2VybmFtZSI6InVzZXIiLCJwYXNzd29yZCI6InBhc3N3b3JkIn0="
 
-	base64Auth, err := base64Auth(buildConfig)
+	base64Auth, err := encodeAuth(buildConfig.AuthConfig)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,258 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Rules reported by linting rendered Dockerfiles
+const (
+	AptNoInstallRecommendsRule = "apt-no-install-recommends"
+	AddLocalFileRule           = "add-local-file"
+	LatestBaseTagRule          = "latest-base-tag"
+	MissingUserRule            = "missing-user"
+)
+
+// dockerfileRule checks instructions of a rendered Dockerfile of an image. Instructions of all images
+// are provided by image ID for the rules which depend on ancestors.
+type dockerfileRule struct {
+	id          string
+	description string
+	check       func(image *Image, instructions map[string][]instruction) []LintIssue
+}
+
+var dockerfileRules = []dockerfileRule{
+	{
+		id:          AptNoInstallRecommendsRule,
+		description: "apt-get install should use --no-install-recommends to avoid installing unnecessary packages",
+		check:       checkAptNoInstallRecommends,
+	},
+	{
+		id:          AddLocalFileRule,
+		description: "COPY should be used instead of ADD for local files and folders",
+		check:       checkAddLocalFile,
+	},
+	{
+		id:          LatestBaseTagRule,
+		description: "base images of root images should be pinned to a specific tag instead of latest",
+		check:       checkLatestBaseTag,
+	},
+	{
+		id:          MissingUserRule,
+		description: "final images should switch to a non-root user with USER instruction",
+		check:       checkMissingUser,
+	},
+}
+
+// instruction is a single Dockerfile instruction with continuation lines joined
+type instruction struct {
+	command   string
+	arguments string
+	line      int
+}
+
+var aptGetInstallPattern = regexp.MustCompile(`apt-get\s+(-\S+\s+)*install`)
+var archivePattern = regexp.MustCompile(`\.(tar|tar\.\w+|tgz|tbz2|txz)$`)
+
+// parseDockerfile splits Dockerfile contents into instructions. Comments and empty lines are skipped,
+// lines ending with a backslash are joined with the following ones.
+func parseDockerfile(content string) []instruction {
+	var instructions []instruction
+	var current *instruction
+	for i, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") || (len(trimmed) == 0 && current == nil) {
+			continue
+		}
+
+		continued := strings.HasSuffix(trimmed, "\\")
+		trimmed = strings.TrimSuffix(trimmed, "\\")
+		if current == nil {
+			fields := strings.SplitN(trimmed, " ", 2)
+			current = &instruction{command: strings.ToUpper(fields[0]), line: i + 1}
+			if len(fields) > 1 {
+				current.arguments = strings.TrimSpace(fields[1])
+			}
+		} else {
+			current.arguments = strings.TrimSpace(current.arguments + " " + trimmed)
+		}
+
+		if !continued {
+			instructions = append(instructions, *current)
+			current = nil
+		}
+	}
+	if current != nil {
+		instructions = append(instructions, *current)
+	}
+	return instructions
+}
+
+// LintDockerfiles applies lint rules to rendered Dockerfiles of all images in the build graph.
+// Rules disabled globally or for a specific image in the config are not applied.
+func LintDockerfiles(root *Image, config BuildConfig) ([]LintIssue, error) {
+	instructions := make(map[string][]instruction)
+	var err error
+	WalkBuildGraph(root, func(image *Image) {
+		if err != nil {
+			return
+		}
+		var content []byte
+		content, err = ioutil.ReadFile(image.Dockerfile)
+		if err != nil {
+			err = fmt.Errorf("failed to read rendered Dockerfile of image %s: %v", image.ImageConfig.Id, err)
+			return
+		}
+		instructions[image.ImageConfig.Id] = parseDockerfile(string(content))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []LintIssue
+	WalkBuildGraph(root, func(image *Image) {
+		for _, rule := range dockerfileRules {
+			issues = append(issues, rule.check(image, instructions)...)
+		}
+	})
+
+	// files are reported relative to the project root
+	for i, issue := range issues {
+		if file, err := config.contextPath(issue.File); err == nil {
+			issues[i].File = file
+		}
+	}
+	return filterDisabledRules(root, config, issues), nil
+}
+
+// newDockerfileIssue creates an issue located in the template or the plain Dockerfile of the image.
+// Issues on lines which can't be mapped to the template are reported in the rendered Dockerfile.
+func newDockerfileIssue(image *Image, rule string, line int, message string) LintIssue {
+	issue := LintIssue{
+		Rule:    rule,
+		Image:   image.ImageConfig.Id,
+		File:    image.Dockerfile,
+		Line:    line,
+		Message: message,
+	}
+	if line == 0 && len(image.sourceFile()) > 0 {
+		issue.File = image.sourceFile()
+	} else if file, templateLine, ok := image.sourceLocation(line); ok && len(file) > 0 {
+		issue.File, issue.Line = file, templateLine
+	}
+	return issue
+}
+
+func checkAptNoInstallRecommends(image *Image, instructions map[string][]instruction) []LintIssue {
+	var issues []LintIssue
+	for _, instruction := range instructions[image.ImageConfig.Id] {
+		if instruction.command == "RUN" && aptGetInstallPattern.MatchString(instruction.arguments) &&
+			!strings.Contains(instruction.arguments, "--no-install-recommends") {
+			issues = append(issues, newDockerfileIssue(image, AptNoInstallRecommendsRule, instruction.line,
+				"apt-get install is used without --no-install-recommends"))
+		}
+	}
+	return issues
+}
+
+func checkAddLocalFile(image *Image, instructions map[string][]instruction) []LintIssue {
+	var issues []LintIssue
+	for _, instruction := range instructions[image.ImageConfig.Id] {
+		if instruction.command != "ADD" {
+			continue
+		}
+		// the last argument is the destination, flags like --chown are skipped
+		var sources []string
+		for _, argument := range strings.Fields(instruction.arguments) {
+			if !strings.HasPrefix(argument, "--") {
+				sources = append(sources, argument)
+			}
+		}
+		if len(sources) > 1 {
+			sources = sources[:len(sources)-1]
+		}
+
+		for _, source := range sources {
+			isURL := strings.Contains(source, "://")
+			isArchive := archivePattern.MatchString(source)
+			if !isURL && !isArchive {
+				issues = append(issues, newDockerfileIssue(image, AddLocalFileRule, instruction.line,
+					fmt.Sprintf("ADD is used for local file %s, use COPY instead", source)))
+				break
+			}
+		}
+	}
+	return issues
+}
+
+// checkLatestBaseTag checks root images only, since child images are based on images of the build
+func checkLatestBaseTag(image *Image, instructions map[string][]instruction) []LintIssue {
+	if image.Parent != nil {
+		return nil
+	}
+
+	var issues []LintIssue
+	for _, from := range baseImageReferences(instructions[image.ImageConfig.Id]) {
+		// images pinned by digest are skipped
+		if _, tag, digest := splitImageReference(from.reference); len(digest) == 0 && tag == "latest" {
+			issues = append(issues, newDockerfileIssue(image, LatestBaseTagRule, from.line,
+				fmt.Sprintf("base image %s uses latest tag", from.reference)))
+		}
+	}
+	return issues
+}
+
+// baseImageReference is an image referenced in a FROM instruction
+type baseImageReference struct {
+	reference string
+	line      int
+}
+
+// baseImageReferences returns images referenced in FROM instructions in the order of instructions except for previous
+// build stages, scratch and references with build arguments
+func baseImageReferences(instructions []instruction) []baseImageReference {
+	var references []baseImageReference
+	var stages []string
+	for _, instruction := range instructions {
+		if instruction.command != "FROM" {
+			continue
+		}
+		var arguments []string
+		for _, argument := range strings.Fields(instruction.arguments) {
+			if !strings.HasPrefix(argument, "--") {
+				arguments = append(arguments, argument)
+			}
+		}
+		if len(arguments) == 0 {
+			continue
+		}
+		reference := arguments[0]
+		isStage := contains(stages, reference)
+		if len(arguments) > 2 && strings.EqualFold(arguments[1], "AS") {
+			stages = append(stages, arguments[2])
+		}
+		if reference == "scratch" || isStage || strings.Contains(reference, "$") {
+			continue
+		}
+		references = append(references, baseImageReference{reference: reference, line: instruction.line})
+	}
+	return references
+}
+
+// checkMissingUser checks images without children, since USER is inherited from ancestors
+// and intermediate images often need root permissions
+func checkMissingUser(image *Image, instructions map[string][]instruction) []LintIssue {
+	if len(image.Children) > 0 {
+		return nil
+	}
+	for current := image; current != nil; current = current.Parent {
+		for _, instruction := range instructions[current.ImageConfig.Id] {
+			if instruction.command == "USER" {
+				return nil
+			}
+		}
+	}
+	return []LintIssue{newDockerfileIssue(image, MissingUserRule, 0, "the image runs as root, no USER instruction found")}
+}
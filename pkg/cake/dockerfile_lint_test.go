@@ -0,0 +1,190 @@
+package cake
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	content := `# comment
+FROM ubuntu:18.04
+
+run apt-get update && \
+    apt-get install -y curl
+USER nobody
+`
+	expected := []instruction{
+		{command: "FROM", arguments: "ubuntu:18.04", line: 2},
+		{command: "RUN", arguments: "apt-get update && apt-get install -y curl", line: 4},
+		{command: "USER", arguments: "nobody", line: 6},
+	}
+
+	instructions := parseDockerfile(content)
+	if !reflect.DeepEqual(expected, instructions) {
+		t.Errorf("Parsed instructions differ from the expected.\nExpected:\n%v\nFound:\n%v", expected, instructions)
+	}
+}
+
+func TestLintDockerfiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dockerfiles := map[string]string{
+		"root": `FROM golang AS builder
+FROM builder
+FROM ubuntu:18.04@sha256:abcdef
+FROM localhost:5000/ubuntu
+RUN apt-get update && \
+    apt-get -y install curl
+RUN apt-get install --no-install-recommends -y wget
+ADD https://example.com/file.txt /file.txt
+ADD files/archive.tar.gz /opt
+ADD --chown=nobody files/config.yaml /etc/config.yaml
+`,
+		"child":    "FROM root\nUSER nobody\n",
+		"leaf":     "FROM child\n",
+		"rootleaf": "FROM root\nADD file.txt /file.txt\n",
+	}
+	for id, content := range dockerfiles {
+		err = ioutil.WriteFile(path.Join(tmpDir, id), []byte(content), 0644)
+		if err != nil {
+			t.Errorf("Failed to write file: %v", err)
+		}
+	}
+
+	images := map[string]*Image{
+		"root":     {ImageConfig: ImageConfig{Id: "root"}},
+		"child":    {ImageConfig: ImageConfig{Id: "child", Parent: "root"}},
+		"leaf":     {ImageConfig: ImageConfig{Id: "leaf", Parent: "child"}},
+		"rootleaf": {ImageConfig: ImageConfig{Id: "rootleaf", Parent: "root", Lint: LintConfig{Disable: []string{AddLocalFileRule}}}},
+	}
+	for id, image := range images {
+		image.Dockerfile = path.Join(tmpDir, id)
+	}
+	root, err := CreateImageBuildGraph(images)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	issues, err := LintDockerfiles(root, BuildConfig{BaseDir: tmpDir})
+	if err != nil {
+		t.Errorf("Unexpected error while linting Dockerfiles: %v", err)
+	}
+
+	expected := []string{
+		"root:5: [apt-no-install-recommends] image root: apt-get install is used without --no-install-recommends",
+		"root:10: [add-local-file] image root: ADD is used for local file files/config.yaml, use COPY instead",
+		"root:1: [latest-base-tag] image root: base image golang uses latest tag",
+		"root:4: [latest-base-tag] image root: base image localhost:5000/ubuntu uses latest tag",
+		"rootleaf: [missing-user] image rootleaf: the image runs as root, no USER instruction found",
+	}
+	var found []string
+	for _, issue := range issues {
+		found = append(found, issue.String())
+	}
+	if !reflect.DeepEqual(expected, found) {
+		t.Errorf("Lint issues differ from the expected.\nExpected:\n%s\nFound:\n%s", expected, found)
+	}
+
+	//disabling rules globally
+	issues, err = LintDockerfiles(root, BuildConfig{BaseDir: tmpDir, Lint: LintConfig{Disable: []string{LatestBaseTagRule, MissingUserRule}}})
+	if err != nil {
+		t.Errorf("Unexpected error while linting Dockerfiles: %v", err)
+	}
+	if len(issues) != 2 {
+		t.Errorf("Expected 2 issues after disabling rules but found: %v", issues)
+	}
+}
+
+func TestLintDockerfileTemplateLocations(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dockerfile := path.Join(tmpDir, ".cake", "generated", "image", "Dockerfile")
+	err = os.MkdirAll(path.Dir(dockerfile), 0755)
+	if err != nil {
+		t.Errorf("Failed to create directory: %v", err)
+	}
+	err = ioutil.WriteFile(dockerfile, []byte("FROM ubuntu\nADD a.txt /\nADD b.txt /\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := &Image{
+		ImageConfig: ImageConfig{Id: "image", Template: path.Join(tmpDir, "image", "Dockerfile.template")},
+		Dockerfile:  dockerfile,
+		// the last ADD comes from a partial and can't be mapped to the template
+		LineMap: []int{2, 5, 0},
+	}
+	issues, err := LintDockerfiles(image, BuildConfig{BaseDir: tmpDir})
+	if err != nil {
+		t.Errorf("Unexpected error while linting Dockerfiles: %v", err)
+	}
+
+	expected := []string{
+		"image/Dockerfile.template:5: [add-local-file] image image: ADD is used for local file a.txt, use COPY instead",
+		".cake/generated/image/Dockerfile:3: [add-local-file] image image: ADD is used for local file b.txt, use COPY instead",
+		"image/Dockerfile.template:2: [latest-base-tag] image image: base image ubuntu uses latest tag",
+		"image/Dockerfile.template: [missing-user] image image: the image runs as root, no USER instruction found",
+	}
+	var found []string
+	for _, issue := range issues {
+		found = append(found, issue.String())
+	}
+	if !reflect.DeepEqual(expected, found) {
+		t.Errorf("Lint issues differ from the expected.\nExpected:\n%s\nFound:\n%s", expected, found)
+	}
+}
+
+func TestWriteLintReport(t *testing.T) {
+	issues := []LintIssue{
+		{Rule: UndefinedPropertyRule, Image: "child", File: "child/Dockerfile.template", Message: "'version' is not defined"},
+		{Rule: AddLocalFileRule, Image: "child", File: "child/Dockerfile.template", Line: 3, Message: "ADD is used for local file a.txt, use COPY instead"},
+	}
+
+	var text bytes.Buffer
+	err := WriteLintReport(&text, issues, TextLintFormat)
+	if err != nil {
+		t.Errorf("Unexpected error while writing lint report: %v", err)
+	}
+	expectedText := `child/Dockerfile.template: [undefined-property] image child: 'version' is not defined
+child/Dockerfile.template:3: [add-local-file] image child: ADD is used for local file a.txt, use COPY instead
+`
+	if text.String() != expectedText {
+		t.Errorf("Text lint report differs from the expected.\nExpected:\n%s\nFound:\n%s", expectedText, text.String())
+	}
+
+	var sarif bytes.Buffer
+	err = WriteLintReport(&sarif, issues, SarifLintFormat)
+	if err != nil {
+		t.Errorf("Unexpected error while writing lint report: %v", err)
+	}
+	var report sarifReport
+	err = json.Unmarshal(sarif.Bytes(), &report)
+	if err != nil {
+		t.Errorf("Failed to parse SARIF report: %v", err)
+	}
+	results := report.Runs[0].Results
+	if len(results) != 2 || results[0].Level != "error" || results[1].Level != "warning" {
+		t.Errorf("Unexpected SARIF results: %v", results)
+	}
+	if results[0].Locations[0].PhysicalLocation.Region != nil || results[1].Locations[0].PhysicalLocation.Region.StartLine != 3 {
+		t.Errorf("Unexpected SARIF locations: %v", results)
+	}
+
+	err = WriteLintReport(&text, issues, "xml")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown report format")
+	}
+}
@@ -12,8 +12,32 @@ type Image struct {
 	ImageConfig ImageConfig
 	Dockerfile  string
 	Checksum    string
-	Parent      *Image
-	Children    []*Image
+	Properties  *PropertyScope
+	Digest      string
+	InputFiles  []string
+	// template line numbers by the index of a line in the rendered Dockerfile, 0 for unknown lines
+	LineMap []int
+	// build arguments passed to Docker build and included in the image checksum
+	BuildArgs map[string]string
+	// digests of external base images of a root image by reference, resolved if base digests are tracked
+	BaseDigests map[string]string
+	// the directory the Dockerfile and templated files of the image are rendered to
+	GeneratedDir string
+	// files rendered from templated_files which are included in the build context and the image checksum
+	GeneratedFiles []string
+	// contents of rendered files by path if the image is rendered in memory without writing to the render directory
+	RenderedFiles map[string][]byte
+	// SHA-256 hashes of files used for the image checksum by file path
+	ChecksumInputs map[string]string
+	// provenance statement of the pushed image and the file it is written to
+	Provenance     *ProvenanceStatement
+	ProvenanceFile string
+	Parent         *Image
+	Children       []*Image
+	// images other than ancestors referenced from the image template which must be built before the image
+	Dependencies []*Image
+	// the image is referenced from templates of other images, so its digest must be known before they are rendered
+	ReferencedFromTemplates bool
 }
 
 func (image Image) String() string {
@@ -31,6 +55,39 @@ func (image Image) getFullName() string {
 	return fmt.Sprintf("%s/%s", image.ImageConfig.Repository, image.ImageConfig.Name)
 }
 
+// isPlainDockerfile returns true if the image is built from a plain Dockerfile instead of a template
+func (image *Image) isPlainDockerfile() bool {
+	return len(image.ImageConfig.Dockerfile) > 0
+}
+
+// sourceFile returns the template or the plain Dockerfile the image is built from
+func (image *Image) sourceFile() string {
+	if image.isPlainDockerfile() {
+		return image.ImageConfig.Dockerfile
+	}
+	return image.ImageConfig.Template
+}
+
+// root returns the root of the build graph the image belongs to
+func (image *Image) root() *Image {
+	root := image
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return root
+}
+
+// findImage returns the image with the provided ID from the build graph or nil if it's not found
+func findImage(graph *Image, id string) *Image {
+	var found *Image
+	WalkBuildGraph(graph, func(image *Image) {
+		if image.ImageConfig.Id == id {
+			found = image
+		}
+	})
+	return found
+}
+
 func (image Image) getDockerTags(config BuildConfig) []string {
 	tags := []string{fmt.Sprintf("%s:%s", image.getFullName(), getTagStr(image, "latest"))}
 
@@ -55,6 +112,11 @@ func (image Image) getStableTag(config BuildConfig) string {
 	}
 }
 
+// StableReference returns the full name of the image with the stable tag
+func (image Image) StableReference(config BuildConfig) string {
+	return fmt.Sprintf("%s:%s", image.getFullName(), image.getStableTag(config))
+}
+
 func (image Image) getChecksumTag(config BuildConfig) string {
 	return getTagStr(image, image.Checksum)
 }
@@ -160,50 +222,190 @@ func CreateImageBuildGraph(images map[string]*Image) (image *Image, err error) {
 	return root, nil
 }
 
-// WalkBuildGraph performs a breadth-first traversal of the tree and applies the provided function to all
-// elements from the same level in order. It is recommended using it when 'apply' function has side-effects
-// which require deterministic ordering e.g. building an ordered slice of image tags.
-func WalkBuildGraph(graph *Image, apply func(image *Image)) {
-	queue := []*Image{graph}
-	for {
-		if len(queue) == 0 {
-			break
+// AddImplicitDependencies adds dependencies on the images referenced from templates (other than ancestors which are
+// dependencies already) to the build graph and checks that the resulting graph doesn't contain cycles
+func AddImplicitDependencies(images map[string]*Image, config BuildConfig) error {
+	var ids []string
+	for key := range images {
+		ids = append(ids, key)
+	}
+	sort.Strings(ids)
+
+	for _, key := range ids {
+		image := images[key]
+		references, err := image.referencedImages(config)
+		if err != nil {
+			return fmt.Errorf("unable to parse template of image %s: %v", image.ImageConfig.Id, err)
+		}
+
+		for _, id := range references {
+			dependency, found := images[id]
+			if !found || dependency == nil {
+				return errors.New(fmt.Sprintf("Unable to find image with ID: %s referenced from the template of image %s", id, image.ImageConfig.Id))
+			}
+			if dependency == image {
+				return errors.New(fmt.Sprintf("Image references itself in the template. Image ID: %s", image.ImageConfig.Id))
+			}
+			dependency.ReferencedFromTemplates = true
+			if dependency.isAncestorOf(image) {
+				continue
+			}
+			image.Dependencies = append(image.Dependencies, dependency)
+		}
+	}
+
+	//checking for cycles introduced by the dependencies
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[*Image]int)
+	var visit func(image *Image) error
+	visit = func(image *Image) error {
+		if state[image] == done {
+			return nil
+		}
+		if state[image] == visiting {
+			return errors.New(fmt.Sprintf("Image dependencies defined in templates have a cycle, aborting. Image ID: %s", image.ImageConfig.Id))
+		}
+		state[image] = visiting
+		dependencies := image.Dependencies
+		if image.Parent != nil {
+			dependencies = append([]*Image{image.Parent}, dependencies...)
+		}
+		for _, dependency := range dependencies {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[image] = done
+		return nil
+	}
+
+	for _, key := range ids {
+		if err := visit(images[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (image *Image) isAncestorOf(other *Image) bool {
+	for ancestor := other.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		if ancestor == image {
+			return true
 		}
+	}
+	return false
+}
+
+// buildLevels groups images of the build graph into levels, so that every image is placed at a level after
+// the levels of its parent and its dependencies. Within a level, images keep the breadth-first traversal order.
+// Without dependencies, the levels are the same as the levels of the tree.
+func buildLevels(graph *Image) [][]*Image {
+	var ordered []*Image
+	queue := []*Image{graph}
+	for len(queue) > 0 {
 		image := queue[0]
-		queue[0] = nil
 		queue = queue[1:]
-		apply(image)
+		ordered = append(ordered, image)
 		queue = append(queue, image.Children...)
 	}
+
+	// parents are always visited before their children in breadth-first order, so repeating the pass is only
+	// required to propagate levels of dependencies, which takes at most one pass per image in an acyclic graph
+	levels := make(map[*Image]int)
+	for pass := 0; pass < len(ordered); pass++ {
+		changed := false
+		for _, image := range ordered {
+			level := 0
+			if image.Parent != nil {
+				level = levels[image.Parent] + 1
+			}
+			for _, dependency := range image.Dependencies {
+				if levels[dependency]+1 > level {
+					level = levels[dependency] + 1
+				}
+			}
+			if level != levels[image] {
+				levels[image] = level
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	maxLevel := 0
+	for _, level := range levels {
+		if level > maxLevel {
+			maxLevel = level
+		}
+	}
+	grouped := make([][]*Image, maxLevel+1)
+	for _, image := range ordered {
+		grouped[levels[image]] = append(grouped[levels[image]], image)
+	}
+
+	// a subtree of the graph can start at a non-zero level
+	var result [][]*Image
+	for _, level := range grouped {
+		if len(level) > 0 {
+			result = append(result, level)
+		}
+	}
+	return result
 }
 
-// WalkBuildGraphParallel performs a breadth-first traversal of the tree and applies the provided function
-// to all elements from the same level in parallel. The provided function should not rely on the ordering of
+// WalkBuildGraph performs a level-by-level traversal of the graph (see buildLevels) and applies the provided
+// function to all elements in order. It is recommended using it when 'apply' function has side-effects
+// which require deterministic ordering e.g. building an ordered slice of image tags.
+func WalkBuildGraph(graph *Image, apply func(image *Image)) {
+	for _, level := range buildLevels(graph) {
+		for _, image := range level {
+			apply(image)
+		}
+	}
+}
+
+// WalkBuildGraphParallel performs a level-by-level traversal of the graph (see buildLevels) and applies the provided
+// function to all elements from the same level in parallel. The provided function should not rely on the ordering of
 // the elements within the same level. The function is called within a goroutine and while it is applied to
 // each element in order, the order of completion is not guaranteed. However, the ordering of levels is
-// always preserved and the new tree level processing doesn't start until all the elements from the previous
-// level are processed.
+// always preserved and the new level processing doesn't start until all the elements from the previous
+// level are processed, so an image is always processed after its parent and its dependencies.
 func WalkBuildGraphParallel(graph *Image, apply func(image *Image)) {
-	queue := []*Image{graph}
-
-	for {
-		if len(queue) == 0 {
-			break
-		}
+	for _, level := range buildLevels(graph) {
 		var wg sync.WaitGroup
-		wg.Add(len(queue))
+		wg.Add(len(level))
 
-		var children []*Image
-		for _, image := range queue {
+		for _, image := range level {
 			go parallelApply(image, apply, &wg)
-			children = append(children, image.Children...)
 		}
 
 		wg.Wait()
-		queue = children
 	}
 }
 
+// walkBuildGraphWithMemoryBudget works like WalkBuildGraphParallel but doesn't start applying the function
+// to an image while the sum of memory declared by the images being processed would exceed the budget.
+// Images which don't declare memory are not limited. A budget of 0 disables the limit.
+func walkBuildGraphWithMemoryBudget(graph *Image, budget int64, memory func(image *Image) int64, apply func(image *Image)) {
+	if budget <= 0 {
+		WalkBuildGraphParallel(graph, apply)
+		return
+	}
+
+	scheduler := newMemoryScheduler(budget)
+	WalkBuildGraphParallel(graph, func(image *Image) {
+		declared := memory(image)
+		scheduler.acquire(declared)
+		defer scheduler.release(declared)
+		apply(image)
+	})
+}
+
 func parallelApply(image *Image, apply func(image *Image), wg *sync.WaitGroup) {
 	defer wg.Done()
 	apply(image)
@@ -2,6 +2,8 @@ package cake
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path"
 	"reflect"
 	"sort"
 	"testing"
@@ -443,3 +445,158 @@ func TestWalkBuildGraphParallel(t *testing.T) {
 			"Found: %s\nTraversal result:\n%s", thirdLevelExpected, thirdLevelActual, visited)
 	}
 }
+
+func TestAddImplicitDependencies(t *testing.T) {
+	/*Testing the following hierarchy with 'app' referencing 'builder' in its template:
+
+	         root
+	          /\
+	         /  \
+	  builder    base
+	               |
+	              app
+
+	*/
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	templates := map[string]string{
+		"root":    "FROM ubuntu",
+		"builder": "FROM {{parent}}",
+		"base":    "FROM {{parent}}",
+		"app":     "FROM {{images.builder.stable_tag}} AS builder\nFROM {{images.root.stable_tag}}\n",
+	}
+	for id, template := range templates {
+		err = ioutil.WriteFile(path.Join(tmpDir, id), []byte(template), 0644)
+		if err != nil {
+			t.Errorf("Failed to write file: %v", err)
+		}
+	}
+
+	sourceImages := map[string]*Image{
+		"root":    {ImageConfig: ImageConfig{Id: "root", Template: path.Join(tmpDir, "root")}},
+		"builder": {ImageConfig: ImageConfig{Id: "builder", Parent: "root", Template: path.Join(tmpDir, "builder")}},
+		"base":    {ImageConfig: ImageConfig{Id: "base", Parent: "root", Template: path.Join(tmpDir, "base")}},
+		"app":     {ImageConfig: ImageConfig{Id: "app", Parent: "base", Template: path.Join(tmpDir, "app")}},
+	}
+
+	root, err := CreateImageBuildGraph(sourceImages)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	err = AddImplicitDependencies(sourceImages, BuildConfig{})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	app := sourceImages["app"]
+	if len(app.Dependencies) != 1 || app.Dependencies[0] != sourceImages["builder"] {
+		t.Errorf("Expected single dependency on 'builder' but found: %v", app.Dependencies)
+	}
+
+	//digests of referenced images including ancestors are resolved for rendering
+	for id, referenced := range map[string]bool{"root": true, "builder": true, "base": false, "app": false} {
+		if sourceImages[id].ReferencedFromTemplates != referenced {
+			t.Errorf("Expected image %s to be referenced from templates: %t", id, referenced)
+		}
+	}
+
+	var visited []string
+	WalkBuildGraph(root, func(image *Image) {
+		visited = append(visited, image.ImageConfig.Id)
+	})
+	expected := []string{"root", "base", "builder", "app"}
+	if !reflect.DeepEqual(expected, visited) {
+		t.Errorf("Node order in a graph traversal differs from the expected.\nExpected:\n%s\nFound:\n%s", expected, visited)
+	}
+}
+
+func TestDependencyLevels(t *testing.T) {
+	/*Testing the following hierarchy with 'child-1' depending on 'child-00':
+
+	         root
+	          /\
+	         /  \
+	  child-0    child-1
+	     |
+	  child-00
+
+	*/
+	sourceImages := map[string]*Image{
+		"root":     {ImageConfig: ImageConfig{Id: "root"}},
+		"child-0":  {ImageConfig: ImageConfig{Id: "child-0", Parent: "root"}},
+		"child-1":  {ImageConfig: ImageConfig{Id: "child-1", Parent: "root"}},
+		"child-00": {ImageConfig: ImageConfig{Id: "child-00", Parent: "child-0"}},
+	}
+
+	root, err := CreateImageBuildGraph(sourceImages)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	sourceImages["child-1"].Dependencies = []*Image{sourceImages["child-00"]}
+
+	channel := make(chan string, len(sourceImages))
+	WalkBuildGraphParallel(root, func(image *Image) {
+		channel <- image.ImageConfig.Id
+	})
+	close(channel)
+
+	var visited []string
+	for element := range channel {
+		visited = append(visited, element)
+	}
+
+	//every level contains a single image, so the ordering is deterministic
+	expected := []string{"root", "child-0", "child-00", "child-1"}
+	if !reflect.DeepEqual(expected, visited) {
+		t.Errorf("Node order in a graph traversal differs from the expected.\nExpected:\n%s\nFound:\n%s", expected, visited)
+	}
+}
+
+func TestImplicitDependencyCycleDetection(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	templates := map[string]string{
+		"root":  "FROM ubuntu",
+		"child": "FROM {{parent}}\nCOPY --from={{images.other.stable_tag}} /bin /bin",
+		"other": "FROM {{parent}}\nCOPY --from={{images.child.stable_tag}} /bin /bin",
+		"self":  "FROM {{parent}}\nCOPY --from={{images.self.stable_tag}} /bin /bin",
+		"ghost": "FROM {{parent}}\nCOPY --from={{images.unknown.stable_tag}} /bin /bin",
+	}
+	for id, template := range templates {
+		err = ioutil.WriteFile(path.Join(tmpDir, id), []byte(template), 0644)
+		if err != nil {
+			t.Errorf("Failed to write file: %v", err)
+		}
+	}
+
+	cases := map[string]string{
+		"other": "Image dependencies defined in templates have a cycle, aborting. Image ID: child",
+		"self":  "Image references itself in the template. Image ID: self",
+		"ghost": "Unable to find image with ID: unknown referenced from the template of image ghost",
+	}
+
+	for id, expectedError := range cases {
+		sourceImages := map[string]*Image{
+			"root": {ImageConfig: ImageConfig{Id: "root", Template: path.Join(tmpDir, "root")}},
+			id:     {ImageConfig: ImageConfig{Id: id, Parent: "root", Template: path.Join(tmpDir, id)}},
+		}
+		if id == "other" {
+			sourceImages["child"] = &Image{ImageConfig: ImageConfig{Id: "child", Parent: "root", Template: path.Join(tmpDir, "child")}}
+		}
+
+		_, err = CreateImageBuildGraph(sourceImages)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+		err = AddImplicitDependencies(sourceImages, BuildConfig{})
+		if err == nil || err.Error() != expectedError {
+			t.Errorf("Expected error '%v', but received = '%v'.", expectedError, err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package cake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+// ImageLabelsReader reads labels and the config digest (image ID) of an image by its name and a tag or a digest
+type ImageLabelsReader interface {
+	ImageLabels(name string, reference string) (map[string]string, error)
+	ConfigDigest(name string, reference string) (string, error)
+}
+
+// RegistryLabelsReader reads labels from the image config stored in the Docker registry hosting the image
+type RegistryLabelsReader struct {
+	Client *ExternalDockerClient
+}
+
+func (reader *RegistryLabelsReader) manifest(name string, reference string) (*schema2.DeserializedManifest, *registry.Registry, string, error) {
+	dockerRegistry, repository, err := reader.Client.RegistryFor(name)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	manifest, err := dockerRegistry.ManifestV2(repository, reference)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to read manifest of %s: %v", name, err)
+	}
+	return manifest, dockerRegistry, repository, nil
+}
+
+func (reader *RegistryLabelsReader) ImageLabels(name string, reference string) (map[string]string, error) {
+	manifest, dockerRegistry, repository, err := reader.manifest(name, reference)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := dockerRegistry.DownloadBlob(repository, manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config of %s: %v", name, err)
+	}
+	defer blob.Close()
+
+	content, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+	var imageConfig struct {
+		Config struct {
+			Labels map[string]string
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(content, &imageConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config of %s: %v", name, err)
+	}
+	return imageConfig.Config.Labels, nil
+}
+
+func (reader *RegistryLabelsReader) ConfigDigest(name string, reference string) (string, error) {
+	manifest, _, _, err := reader.manifest(name, reference)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Config.Digest.String(), nil
+}
+
+// DaemonLabelsReader reads labels of images available in the local Docker daemon
+type DaemonLabelsReader struct {
+	Client *client.Client
+}
+
+func (reader *DaemonLabelsReader) ImageLabels(name string, reference string) (map[string]string, error) {
+	inspect, err := reader.inspect(name, reference)
+	if err != nil {
+		return nil, err
+	}
+	if inspect.Config == nil {
+		return nil, nil
+	}
+	return inspect.Config.Labels, nil
+}
+
+func (reader *DaemonLabelsReader) ConfigDigest(name string, reference string) (string, error) {
+	inspect, err := reader.inspect(name, reference)
+	if err != nil {
+		return "", err
+	}
+	return inspect.ID, nil
+}
+
+func (reader *DaemonLabelsReader) inspect(name string, reference string) (types.ImageInspect, error) {
+	separator := ":"
+	if strings.Contains(reference, ":") {
+		separator = "@"
+	}
+	inspect, _, err := reader.Client.ImageInspectWithRaw(context.Background(), name+separator+reference)
+	return inspect, err
+}
+
+// ImageLineage is the build information of an image decoded from the labels added by cake
+type ImageLineage struct {
+	Reference    string
+	ImageId      string                 `json:",omitempty"`
+	Checksum     string                 `json:",omitempty"`
+	Revision     string                 `json:",omitempty"`
+	Source       string                 `json:",omitempty"`
+	Created      string                 `json:",omitempty"`
+	CakeVersion  string                 `json:",omitempty"`
+	Parent       string                 `json:",omitempty"`
+	ParentDigest string                 `json:",omitempty"`
+	BaseDigests  map[string]string      `json:",omitempty"`
+	Properties   map[string]interface{} `json:",omitempty"`
+}
+
+// SplitImageReference splits an image reference into the name and a tag or a digest, 'latest' tag is used by default.
+// The digest takes precedence over the tag if both are present.
+func SplitImageReference(image string) (string, string) {
+	name, tag, digest := splitImageReference(image)
+	if len(digest) > 0 {
+		return name, digest
+	}
+	return name, tag
+}
+
+// splitImageReference splits a reference in the form of 'name[:tag][@digest]'. No tag is reported for references
+// pinned by digest only, 'latest' tag is used for references without a tag and a digest.
+func splitImageReference(reference string) (name string, tag string, digest string) {
+	if i := strings.Index(reference, "@"); i >= 0 {
+		reference, digest = reference[:i], reference[i+1:]
+	}
+	// the tag follows the last colon unless the colon belongs to a registry host with port
+	if i := strings.LastIndex(reference, ":"); i > strings.LastIndex(reference, "/") {
+		return reference[:i], reference[i+1:], digest
+	}
+	if len(digest) > 0 {
+		return reference, "", digest
+	}
+	return reference, "latest", digest
+}
+
+// InspectImage decodes cake labels of the image and walks up its ancestors. The image comes first in the returned
+// chain followed by its parent, the parent of the parent and so on. Parents are looked up by digest when it's known.
+func InspectImage(reader ImageLabelsReader, image string) ([]ImageLineage, error) {
+	var chain []ImageLineage
+	visited := make(map[string]bool)
+	for reference := image; len(reference) > 0; {
+		if visited[reference] {
+			return nil, fmt.Errorf("image %s references itself as an ancestor", reference)
+		}
+		visited[reference] = true
+
+		name, tagOrDigest := SplitImageReference(reference)
+		labels, err := reader.ImageLabels(name, tagOrDigest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read labels of %s: %v", reference, err)
+		}
+		if len(labels[ImageIdLabel]) == 0 {
+			return nil, fmt.Errorf("image %s was not built by cake or has automatic labels turned off", reference)
+		}
+
+		lineage := ImageLineage{
+			Reference:    reference,
+			ImageId:      labels[ImageIdLabel],
+			Checksum:     labels[ChecksumLabel],
+			Revision:     labels[RevisionLabel],
+			Source:       labels[SourceLabel],
+			Created:      labels[CreatedLabel],
+			CakeVersion:  labels[CakeVersionLabel],
+			Parent:       labels[ParentLabel],
+			ParentDigest: labels[ParentDigestLabel],
+		}
+		if properties, found := labels[PropertiesLabel]; found {
+			if err := json.Unmarshal([]byte(properties), &lineage.Properties); err != nil {
+				return nil, fmt.Errorf("failed to parse properties of %s: %v", reference, err)
+			}
+		}
+		if digests, found := labels[BaseDigestsLabel]; found {
+			if err := json.Unmarshal([]byte(digests), &lineage.BaseDigests); err != nil {
+				return nil, fmt.Errorf("failed to parse base image digests of %s: %v", reference, err)
+			}
+		}
+		chain = append(chain, lineage)
+
+		reference = lineage.Parent
+		if len(lineage.Parent) > 0 && len(lineage.ParentDigest) > 0 {
+			parentName, _ := SplitImageReference(lineage.Parent)
+			reference = fmt.Sprintf("%s@%s", parentName, lineage.ParentDigest)
+		}
+	}
+	return chain, nil
+}
+
+// WriteLineage writes the image lineage in text or JSON format
+func WriteLineage(writer io.Writer, chain []ImageLineage, format string) error {
+	switch format {
+	case TextFormat:
+		tabs := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+		for i, lineage := range chain {
+			if i > 0 {
+				fmt.Fprintln(tabs)
+			}
+			fmt.Fprintf(tabs, "%s\n", lineage.Reference)
+			fmt.Fprintf(tabs, "  image id:\t%s\n", lineage.ImageId)
+			fmt.Fprintf(tabs, "  checksum:\t%s\n", lineage.Checksum)
+			fmt.Fprintf(tabs, "  revision:\t%s\n", lineage.Revision)
+			fmt.Fprintf(tabs, "  source:\t%s\n", lineage.Source)
+			fmt.Fprintf(tabs, "  created:\t%s\n", lineage.Created)
+			fmt.Fprintf(tabs, "  cake version:\t%s\n", lineage.CakeVersion)
+			if len(lineage.Parent) > 0 {
+				fmt.Fprintf(tabs, "  parent:\t%s\n", lineage.Parent)
+			}
+			if len(lineage.BaseDigests) > 0 {
+				fmt.Fprintf(tabs, "  base images:\t%s\n", formatDigests(lineage.BaseDigests))
+			}
+			if len(lineage.Properties) > 0 {
+				fmt.Fprintln(tabs, "  properties:")
+				for _, name := range sortedNames(lineage.Properties) {
+					fmt.Fprintf(tabs, "    %s\t%s\n", name, FormatPropertyValue(lineage.Properties[name]))
+				}
+			}
+		}
+		return tabs.Flush()
+	case JsonFormat:
+		return writeJson(writer, chain)
+	default:
+		return fmt.Errorf("unknown output format '%s', supported formats are: %s, %s", format, TextFormat, JsonFormat)
+	}
+}
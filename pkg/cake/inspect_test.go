@@ -0,0 +1,113 @@
+package cake
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockLabelsReader returns labels of images by references in the form of 'name:tag' or 'name@digest'
+type mockLabelsReader map[string]map[string]string
+
+func (reader mockLabelsReader) ImageLabels(name string, reference string) (map[string]string, error) {
+	for _, key := range []string{name + ":" + reference, name + "@" + reference} {
+		if labels, found := reader[key]; found {
+			return labels, nil
+		}
+	}
+	return nil, fmt.Errorf("image %s:%s not found", name, reference)
+}
+
+// ConfigDigest returns the checksum label as a digest of the image
+func (reader mockLabelsReader) ConfigDigest(name string, reference string) (string, error) {
+	labels, err := reader.ImageLabels(name, reference)
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + labels[ChecksumLabel], nil
+}
+
+func TestSplitImageReference(t *testing.T) {
+	for reference, expected := range map[string][]string{
+		"repo/app:1.0":                {"repo/app", "1.0"},
+		"repo/app":                    {"repo/app", "latest"},
+		"localhost:5000/repo/app":     {"localhost:5000/repo/app", "latest"},
+		"localhost:5000/repo/app:1.0": {"localhost:5000/repo/app", "1.0"},
+		"repo/app@sha256:abc":         {"repo/app", "sha256:abc"},
+		"repo/app:1.0@sha256:abc":     {"repo/app", "sha256:abc"},
+	} {
+		name, tag := SplitImageReference(reference)
+		assert.Equal(t, expected, []string{name, tag}, reference)
+	}
+
+	name, tag, digest := splitImageReference("localhost:5000/ubuntu:18.04@sha256:123")
+	assert.Equal(t, []string{"localhost:5000/ubuntu", "18.04", "sha256:123"}, []string{name, tag, digest})
+
+	name, tag, digest = splitImageReference("ubuntu@sha256:123")
+	assert.Equal(t, []string{"ubuntu", "", "sha256:123"}, []string{name, tag, digest})
+
+	name, tag, digest = splitImageReference("ubuntu")
+	assert.Equal(t, []string{"ubuntu", "latest", ""}, []string{name, tag, digest})
+}
+
+func TestInspectImage(t *testing.T) {
+	reader := mockLabelsReader{
+		"repo/app:1.0": {
+			ImageIdLabel:      "app",
+			ChecksumLabel:     "def",
+			RevisionLabel:     "0123abc",
+			ParentLabel:       "repo/base:abc",
+			ParentDigestLabel: "sha256:123",
+			PropertiesLabel:   `{"packages":["curl"],"version":"1.0"}`,
+		},
+		"repo/base@sha256:123": {
+			ImageIdLabel:  "base",
+			ChecksumLabel: "abc",
+			ParentLabel:   "repo/root:1.0",
+		},
+		"repo/root:1.0": {
+			ImageIdLabel:     "root",
+			ChecksumLabel:    "789",
+			BaseDigestsLabel: `{"ubuntu:18.04":"sha256:456"}`,
+		},
+		"repo/unlabeled:1.0": {},
+	}
+
+	chain, err := InspectImage(reader, "repo/app:1.0")
+	if err != nil {
+		t.Errorf("Unexpected error while inspecting image: %v", err)
+	}
+
+	var ids []string
+	for _, lineage := range chain {
+		ids = append(ids, lineage.ImageId)
+	}
+	assert.Equal(t, []string{"app", "base", "root"}, ids)
+	assert.Equal(t, "repo/base@sha256:123", chain[1].Reference)
+	assert.Equal(t, "0123abc", chain[0].Revision)
+	assert.Equal(t, map[string]interface{}{"packages": []interface{}{"curl"}, "version": "1.0"}, chain[0].Properties)
+	assert.Equal(t, map[string]string{"ubuntu:18.04": "sha256:456"}, chain[2].BaseDigests)
+
+	var out bytes.Buffer
+	err = WriteLineage(&out, chain, TextFormat)
+	if err != nil {
+		t.Errorf("Unexpected error while writing lineage: %v", err)
+	}
+	assert.Contains(t, out.String(), "repo/app:1.0\n  image id:      app\n")
+	assert.Contains(t, out.String(), `packages  ["curl"]`)
+	assert.Equal(t, 3, strings.Count(out.String(), "image id:"))
+	assert.Contains(t, out.String(), "[ubuntu:18.04@sha256:456]")
+
+	_, err = InspectImage(reader, "repo/unlabeled:1.0")
+	if err == nil {
+		t.Errorf("Expected an error for an image without cake labels")
+	}
+
+	_, err = InspectImage(reader, "repo/missing:1.0")
+	if err == nil {
+		t.Errorf("Expected an error for an image which doesn't exist")
+	}
+}
@@ -0,0 +1,93 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lineMarkerPattern matches markers of template lines added before rendering to track where every rendered line
+// comes from. Markers use a NUL character which is not expected to appear in templates.
+var lineMarkerPattern = regexp.MustCompile("\x00([0-9]+)\x00")
+
+// standaloneTagPattern matches lines which consist of a single mustache tag removed from the output
+// together with the line, e.g. '{{#packages}}' or '{{> partial}}'
+var standaloneTagPattern = regexp.MustCompile(`^\s*\{\{\s*[#^/!>=][^}]*\}\}\s*$`)
+
+// markTemplateLines adds a marker with the line number to every template line after the leading whitespace.
+// Lines which would change rendering if marked (standalone mustache tags and Go template actions trimming
+// preceding whitespace) are left as is.
+func markTemplateLines(content string, engine string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if len(trimmed) == 0 {
+			continue
+		}
+		if engine == MustacheEngine && standaloneTagPattern.MatchString(line) {
+			continue
+		}
+		if engine == GoTemplateEngine && strings.HasPrefix(trimmed, "{{-") {
+			continue
+		}
+		lines[i] = fmt.Sprintf("%s\x00%d\x00%s", line[:len(line)-len(trimmed)], i+1, trimmed)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lineMap renders the template with marked lines and returns template line numbers by rendered line index.
+// Rendered lines which can't be attributed to a template line (e.g. lines of partials) are mapped to 0.
+// If the marked template renders differently from the original one, no map is returned.
+func (context *templateContext) lineMap(templateFile string, rendered string) []int {
+	content, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		return nil
+	}
+	marked, err := context.renderContent(templateFile, markTemplateLines(string(content), context.engine))
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(marked, "\n")
+	lineMap := make([]int, len(lines))
+	for i, line := range lines {
+		if match := lineMarkerPattern.FindStringSubmatch(line); match != nil {
+			lineMap[i], _ = strconv.Atoi(match[1])
+		}
+	}
+
+	if lineMarkerPattern.ReplaceAllString(marked, "") != rendered {
+		return nil
+	}
+	return lineMap
+}
+
+// TemplateLocation returns the template line the instruction executed at the build step (starting from 1)
+// of the rendered Dockerfile comes from. Plain Dockerfiles are not rendered, so their own lines are returned.
+func (image *Image) TemplateLocation(step int) (string, int, bool) {
+	content, err := ioutil.ReadFile(image.Dockerfile)
+	if err != nil {
+		return "", 0, false
+	}
+
+	instructions := parseDockerfile(string(content))
+	if step < 1 || step > len(instructions) {
+		return "", 0, false
+	}
+
+	return image.sourceLocation(instructions[step-1].line)
+}
+
+// sourceLocation returns the template line the line of the rendered Dockerfile (starting from 1) comes from.
+// Plain Dockerfiles are not rendered, so their own lines are returned.
+func (image *Image) sourceLocation(line int) (string, int, bool) {
+	if image.isPlainDockerfile() {
+		return image.ImageConfig.Dockerfile, line, true
+	}
+	if line < 1 || line > len(image.LineMap) || image.LineMap[line-1] == 0 {
+		return "", 0, false
+	}
+	return image.ImageConfig.Template, image.LineMap[line-1], true
+}
@@ -0,0 +1,125 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestLineMap(t *testing.T) {
+	cases := []struct {
+		engine          string
+		template        string
+		expectedLineMap []int
+	}{
+		{
+			engine: MustacheEngine,
+			template: `FROM {{parent}}
+
+{{#packages}}
+RUN install {{.}}
+{{/packages}}
+  ENV VERSION {{version}}
+`,
+			// FROM, empty line, two iterations of the section, ENV and the trailing empty line
+			expectedLineMap: []int{1, 0, 4, 4, 6, 0},
+		},
+		{
+			engine: GoTemplateEngine,
+			template: `FROM {{ .parent }}
+{{- if eq .version "1.0" }}
+RUN echo {{ .version }}
+{{- end }}
+USER nobody
+`,
+			expectedLineMap: []int{1, 3, 5, 0},
+		},
+	}
+
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, testCase := range cases {
+		templateFile := path.Join(tmpDir, "Dockerfile.template")
+		err = ioutil.WriteFile(templateFile, []byte(testCase.template), 0644)
+		if err != nil {
+			t.Errorf("Failed to write file: %v", err)
+		}
+
+		image := &Image{
+			Parent: &Image{ImageConfig: ImageConfig{Id: "root", Repository: "foo", Name: "bar"}},
+			ImageConfig: ImageConfig{
+				Id:         "child",
+				Template:   templateFile,
+				Engine:     testCase.engine,
+				Properties: Properties{"version": "1.0", "packages": []interface{}{"curl", "wget"}},
+			},
+		}
+		err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
+		if err != nil {
+			t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+		}
+
+		if !reflect.DeepEqual(testCase.expectedLineMap, image.LineMap) {
+			t.Errorf("Line map of %s template differs from the expected.\nExpected:\n%v\nFound:\n%v",
+				testCase.engine, testCase.expectedLineMap, image.LineMap)
+		}
+	}
+
+	//images which are not rendered have no locations
+	file, line, found := (&Image{}).TemplateLocation(1)
+	if found {
+		t.Errorf("Expected no location for an image which is not rendered but found %s:%d", file, line)
+	}
+}
+
+func TestTemplateLocation(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte(`# comment
+FROM ubuntu
+
+RUN apt-get update && \
+    apt-get install -y curl
+{{> env}}
+RUN {{command}}
+`), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	err = ioutil.WriteFile(path.Join(tmpDir, "env"), []byte("ENV A b\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := &Image{ImageConfig: ImageConfig{Id: "image", Template: templateFile, Properties: Properties{"command": "false"}}}
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	expected := map[int]int{1: 2, 2: 4, 4: 7}
+	for step, expectedLine := range expected {
+		file, line, found := image.TemplateLocation(step)
+		if !found || file != templateFile || line != expectedLine {
+			t.Errorf("Expected step %d to be mapped to %s:%d but found %s:%d", step, templateFile, expectedLine, file, line)
+		}
+	}
+
+	//instructions rendered from partials and nonexistent steps are not mapped
+	for _, step := range []int{3, 5} {
+		if file, line, found := image.TemplateLocation(step); found {
+			t.Errorf("Expected step %d not to be mapped but found %s:%d", step, file, line)
+		}
+	}
+}
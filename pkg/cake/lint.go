@@ -0,0 +1,442 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/cbroglie/mustache"
+)
+
+// Rules reported by template linting
+const (
+	UndefinedPropertyRule = "undefined-property"
+	UnusedPropertyRule    = "unused-property"
+	ShadowedPropertyRule  = "shadowed-property"
+	ParentFromRule        = "parent-from"
+)
+
+var templateRuleDescriptions = map[string]string{
+	UndefinedPropertyRule: "variables used in templates must be defined for the image",
+	UnusedPropertyRule:    "properties should be used in templates of the image or its descendants",
+	ShadowedPropertyRule:  "image properties should not repeat the inherited values",
+	ParentFromRule:        "the first FROM instruction of a child image template should use the parent image",
+}
+
+// parentFromPattern matches FROM instructions which use the parent property as a base image,
+// e.g. 'FROM {{parent}}', 'FROM --platform=linux/amd64 {{ .parent }} AS base'
+var parentFromPattern = regexp.MustCompile(`(?i)^\s*FROM\s+(--\S+\s+)*\{\{[{\s-]*\$?\.?parent[\s}-]*\}\}(\s|$)`)
+
+// parentArgFromPattern matches FROM instructions of plain Dockerfiles which use the parent build argument,
+// e.g. 'FROM ${PARENT_IMAGE}' or 'FROM $PARENT_IMAGE AS base'
+var parentArgFromPattern = regexp.MustCompile(`(?i)^\s*FROM\s+(--\S+\s+)*\$(\{` + ParentImageBuildArg + `\}|` + ParentImageBuildArg + `)(\s|$)`)
+
+// LintIssue is a problem found in templates or property definitions
+type LintIssue struct {
+	Rule    string
+	Image   string
+	File    string
+	Line    int
+	Message string
+}
+
+func (issue LintIssue) String() string {
+	location := issue.File
+	if issue.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, issue.Line)
+	}
+	if len(issue.Image) > 0 {
+		return fmt.Sprintf("%s: [%s] image %s: %s", location, issue.Rule, issue.Image, issue.Message)
+	}
+	return fmt.Sprintf("%s: [%s] %s", location, issue.Rule, issue.Message)
+}
+
+// LintTemplates parses templates and templated files of all images in the build graph and reports variables which
+// are not defined, properties which are not used by any template, image properties which have the same value
+// as the inherited ones, and templates of child images which don't use the parent in the first FROM instruction.
+// The config file name is used as a location of issues with property definitions.
+func LintTemplates(root *Image, config BuildConfig, configFile string) ([]LintIssue, error) {
+	var issues []LintIssue
+	usages := make(map[string]map[string]bool)
+
+	var err error
+	WalkBuildGraph(root, func(image *Image) {
+		if err != nil {
+			return
+		}
+		var imageIssues []LintIssue
+		imageIssues, usages[image.ImageConfig.Id], err = image.lintTemplates(config)
+		issues = append(issues, imageIssues...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// global properties are used if any template uses them, image properties are used if the template
+	// of the image or one of its descendants uses them
+	for _, name := range sortedNames(config.GlobalProperties) {
+		used := false
+		for _, usage := range usages {
+			used = used || usage[name]
+		}
+		if !used {
+			issues = append(issues, LintIssue{
+				Rule:    UnusedPropertyRule,
+				File:    configFile,
+				Message: fmt.Sprintf("global property '%s' is not used in any template", name),
+			})
+		}
+	}
+
+	WalkBuildGraph(root, func(image *Image) {
+		for _, name := range sortedNames(image.ImageConfig.Properties) {
+			used := false
+			WalkBuildGraph(image, func(descendant *Image) {
+				used = used || usages[descendant.ImageConfig.Id][name]
+			})
+			if !used {
+				issues = append(issues, LintIssue{
+					Rule:    UnusedPropertyRule,
+					Image:   image.ImageConfig.Id,
+					File:    configFile,
+					Message: fmt.Sprintf("property '%s' is not used in templates of the image or its descendants", name),
+				})
+			}
+
+			if value, source, inherited := image.inheritedProperty(name, config); inherited &&
+				reflect.DeepEqual(value, image.ImageConfig.Properties[name]) {
+				issues = append(issues, LintIssue{
+					Rule:    ShadowedPropertyRule,
+					Image:   image.ImageConfig.Id,
+					File:    configFile,
+					Message: fmt.Sprintf("property '%s' has the same value as the one inherited from %s", name, source),
+				})
+			}
+		}
+	})
+
+	return filterDisabledRules(root, config, issues), nil
+}
+
+// filterDisabledRules removes issues reported by rules disabled globally or for the image the issue belongs to
+func filterDisabledRules(root *Image, config BuildConfig, issues []LintIssue) []LintIssue {
+	var filtered []LintIssue
+	for _, issue := range issues {
+		if contains(config.Lint.Disable, issue.Rule) {
+			continue
+		}
+		if image := findImage(root, issue.Image); image != nil && contains(image.ImageConfig.Lint.Disable, issue.Rule) {
+			continue
+		}
+		filtered = append(filtered, issue)
+	}
+	return filtered
+}
+
+// lintTemplates reports issues in the template and templated files of the image
+// and returns names of properties used by them
+func (image *Image) lintTemplates(config BuildConfig) ([]LintIssue, map[string]bool, error) {
+	values := ResolveProperties(image, config).Values()
+	values["images"] = imagesNamespace(image, config)
+	values["generated_dir"] = ""
+
+	usage := &propertyUsage{values: values, used: make(map[string]bool)}
+	context := &templateContext{image: image, config: config, engine: templateEngine(image, config)}
+
+	files := image.ImageConfig.TemplatedFiles
+	if !image.isPlainDockerfile() {
+		files = append([]string{image.ImageConfig.Template}, files...)
+	} else if err := usage.collectBuildArgs(image.ImageConfig.Dockerfile); err != nil {
+		return nil, nil, fmt.Errorf("failed to read Dockerfile %s: %v", image.ImageConfig.Dockerfile, err)
+	}
+
+	var issues []LintIssue
+	for _, file := range files {
+		usage.undefined = nil
+		var err error
+		switch context.engine {
+		case MustacheEngine:
+			err = usage.collectMustache(file, context)
+		case GoTemplateEngine:
+			err = usage.collectGoTemplate(file, context)
+		default:
+			err = fmt.Errorf("unknown template engine '%s' for image %s", context.engine, image.ImageConfig.Id)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse template %s: %v", file, err)
+		}
+
+		for _, name := range usage.undefined {
+			issues = append(issues, LintIssue{
+				Rule:    UndefinedPropertyRule,
+				Image:   image.ImageConfig.Id,
+				File:    file,
+				Message: fmt.Sprintf("'%s' is not defined", name),
+			})
+		}
+	}
+
+	if image.Parent != nil {
+		issue, err := image.lintParentFrom()
+		if err != nil {
+			return nil, nil, err
+		}
+		if issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+
+	return issues, usage.used, nil
+}
+
+// lintParentFrom reports the first FROM instruction of the template or the plain Dockerfile
+// if it doesn't use the parent image
+func (image *Image) lintParentFrom() (*LintIssue, error) {
+	content, err := ioutil.ReadFile(image.sourceFile())
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := parentFromPattern
+	if image.isPlainDockerfile() {
+		pattern = parentArgFromPattern
+	}
+
+	issue := &LintIssue{
+		Rule:    ParentFromRule,
+		Image:   image.ImageConfig.Id,
+		File:    image.sourceFile(),
+		Message: fmt.Sprintf("the first FROM instruction doesn't use the parent image %s", image.Parent.ImageConfig.Id),
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		if pattern.MatchString(line) {
+			return nil, nil
+		}
+		issue.Line = i + 1
+		return issue, nil
+	}
+	issue.Message = fmt.Sprintf("%s has no FROM instruction", image.sourceFile())
+	return issue, nil
+}
+
+// inheritedProperty returns the value the image would inherit from its ancestors or global properties
+// if the property wasn't defined for the image itself
+func (image *Image) inheritedProperty(name string, config BuildConfig) (interface{}, string, bool) {
+	for ancestor := image.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		if value, found := ancestor.ImageConfig.Properties[name]; found {
+			return value, fmt.Sprintf("ancestor %s", ancestor.ImageConfig.Id), true
+		}
+	}
+	if value, found := config.GlobalProperties[name]; found {
+		return value, "global properties", true
+	}
+	return nil, "", false
+}
+
+// propertyUsage collects names of properties used by templates and names which are not defined
+type propertyUsage struct {
+	values    map[string]interface{}
+	used      map[string]bool
+	undefined []string
+}
+
+func (usage *propertyUsage) addUndefined(name string) {
+	if !contains(usage.undefined, name) {
+		usage.undefined = append(usage.undefined, name)
+	}
+}
+
+// lookup resolves a (dotted) name against the section values starting from the innermost one and then against
+// template values. Only names resolved against template values are registered as used properties.
+func (usage *propertyUsage) lookup(name string, sections []interface{}) (interface{}, bool) {
+	path := strings.Split(name, ".")
+	for i := len(sections) - 1; i >= 0; i-- {
+		if value, found := lookupPath(sections[i], path); found {
+			return value, true
+		}
+	}
+
+	value, found := lookupPath(usage.values, path)
+	if _, defined := usage.values[path[0]]; defined {
+		usage.used[path[0]] = true
+	}
+	return value, found
+}
+
+// lookupPath resolves a path in nested maps. Lists are looked up in their elements.
+func lookupPath(value interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return value, true
+	}
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if item, found := typed[path[0]]; found {
+			return lookupPath(item, path[1:])
+		}
+	case []interface{}:
+		for _, item := range typed {
+			if value, found := lookupPath(item, path); found {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// collectBuildArgs marks properties declared with ARG instructions of a plain Dockerfile as used
+func (usage *propertyUsage) collectBuildArgs(dockerfile string) error {
+	content, err := ioutil.ReadFile(dockerfile)
+	if err != nil {
+		return err
+	}
+	for _, instruction := range parseDockerfile(string(content)) {
+		if instruction.command != "ARG" {
+			continue
+		}
+		for _, argument := range strings.Fields(instruction.arguments) {
+			usage.used[strings.SplitN(argument, "=", 2)[0]] = true
+		}
+	}
+	return nil
+}
+
+func (usage *propertyUsage) collectMustache(file string, context *templateContext) error {
+	provider := &partialProvider{context: context, directory: context.partialsDir(file)}
+	tmpl, err := mustache.ParseFilePartials(file, provider)
+	if err != nil {
+		return err
+	}
+
+	visited := make(map[string]bool)
+	var walk func(tags []mustache.Tag, sections []interface{}) error
+	walk = func(tags []mustache.Tag, sections []interface{}) error {
+		for _, tag := range tags {
+			switch tag.Type() {
+			case mustache.Variable, mustache.Section, mustache.InvertedSection:
+				var value interface{}
+				if tag.Name() != "." {
+					var found bool
+					value, found = usage.lookup(tag.Name(), sections)
+					if !found {
+						usage.addUndefined(tag.Name())
+					}
+				}
+				if tag.Type() != mustache.Variable {
+					if err := walk(tag.Tags(), append(sections, value)); err != nil {
+						return err
+					}
+				}
+			case mustache.Partial:
+				if visited[tag.Name()] {
+					continue
+				}
+				visited[tag.Name()] = true
+				content, err := provider.Get(tag.Name())
+				if err != nil {
+					return err
+				}
+				partial, err := mustache.ParseStringPartials(content, provider)
+				if err != nil {
+					return err
+				}
+				if err := walk(partial.Tags(), sections); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(tmpl.Tags(), nil)
+}
+
+// collectGoTemplate checks fields of the template data. Fields inside 'range' and 'with' blocks refer to
+// a different value and are not checked, partials are assumed to be included with the template data.
+func (usage *propertyUsage) collectGoTemplate(file string, context *templateContext) error {
+	tmpl, _, err := parseGoTemplate(file, context.partialsDir(file), context.funcs())
+	if err != nil {
+		return err
+	}
+
+	for _, included := range tmpl.Templates() {
+		if included.Tree == nil {
+			continue
+		}
+		walkGoTemplateScoped(included.Tree.Root, true, func(node parse.Node, isRoot bool) {
+			var path []string
+			switch typed := node.(type) {
+			case *parse.FieldNode:
+				if isRoot {
+					path = typed.Ident
+				}
+			case *parse.VariableNode:
+				if len(typed.Ident) > 1 && typed.Ident[0] == "$" {
+					path = typed.Ident[1:]
+				}
+			case *parse.CommandNode:
+				// properties accessed via 'index . "<name>"' are optional
+				if len(typed.Args) > 2 && isRoot {
+					function, isIdentifier := typed.Args[0].(*parse.IdentifierNode)
+					_, isDot := typed.Args[1].(*parse.DotNode)
+					name, isString := typed.Args[2].(*parse.StringNode)
+					if isIdentifier && function.Ident == "index" && isDot && isString {
+						usage.lookup(name.Text, nil)
+					}
+				}
+			}
+			if len(path) > 0 {
+				if _, found := usage.lookup(strings.Join(path, "."), nil); !found {
+					usage.addUndefined(strings.Join(path, "."))
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// walkGoTemplateScoped applies the function to all nodes of a Go template parse tree along with the flag
+// indicating whether the dot refers to the template data at the node
+func walkGoTemplateScoped(node parse.Node, isRoot bool, visit func(node parse.Node, isRoot bool)) {
+	switch typed := node.(type) {
+	case *parse.RangeNode:
+		walkGoTemplateScoped(typed.Pipe, isRoot, visit)
+		walkGoTemplateScoped(typed.List, false, visit)
+		walkGoTemplateScoped(typed.ElseList, isRoot, visit)
+	case *parse.WithNode:
+		walkGoTemplateScoped(typed.Pipe, isRoot, visit)
+		walkGoTemplateScoped(typed.List, false, visit)
+		walkGoTemplateScoped(typed.ElseList, isRoot, visit)
+	case *parse.IfNode:
+		walkGoTemplateScoped(typed.Pipe, isRoot, visit)
+		walkGoTemplateScoped(typed.List, isRoot, visit)
+		walkGoTemplateScoped(typed.ElseList, isRoot, visit)
+	case *parse.ListNode:
+		if typed == nil {
+			return
+		}
+		for _, child := range typed.Nodes {
+			walkGoTemplateScoped(child, isRoot, visit)
+		}
+	default:
+		// other nodes don't change the dot, so the generic walker is used with a fixed scope
+		walkGoTemplate(node, func(node parse.Node) {
+			visit(node, isRoot)
+		})
+	}
+}
+
+func sortedNames(properties Properties) []string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
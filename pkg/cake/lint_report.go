@@ -0,0 +1,154 @@
+package cake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Output formats of lint reports
+const (
+	TextLintFormat  = TextFormat
+	JsonLintFormat  = JsonFormat
+	SarifLintFormat = "sarif"
+)
+
+// sarifReport is a minimal subset of SARIF 2.1.0 supported by CI systems for code annotations
+type sarifReport struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationUri string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	Id               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleId    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	Uri string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// WriteLintReport writes lint issues in one of the supported formats: text (one issue per line), JSON or SARIF
+func WriteLintReport(writer io.Writer, issues []LintIssue, format string) error {
+	switch format {
+	case TextLintFormat:
+		for _, issue := range issues {
+			if _, err := fmt.Fprintln(writer, issue); err != nil {
+				return err
+			}
+		}
+		return nil
+	case JsonLintFormat:
+		if issues == nil {
+			issues = []LintIssue{}
+		}
+		return writeJson(writer, issues)
+	case SarifLintFormat:
+		return writeJson(writer, newSarifReport(issues))
+	default:
+		return fmt.Errorf("unknown lint report format '%s', supported formats are: %s, %s, %s",
+			format, TextLintFormat, JsonLintFormat, SarifLintFormat)
+	}
+}
+
+func writeJson(writer io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func newSarifReport(issues []LintIssue) sarifReport {
+	descriptions := make(map[string]string)
+	for id, description := range templateRuleDescriptions {
+		descriptions[id] = description
+	}
+	for _, rule := range dockerfileRules {
+		descriptions[rule.id] = rule.description
+	}
+
+	var rules []sarifRule
+	for id, description := range descriptions {
+		rules = append(rules, sarifRule{Id: id, ShortDescription: sarifMessage{Text: description}})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Id < rules[j].Id
+	})
+
+	results := make([]sarifResult, 0)
+	for _, issue := range issues {
+		level := "warning"
+		if issue.Rule == UndefinedPropertyRule {
+			level = "error"
+		}
+		message := issue.Message
+		if len(issue.Image) > 0 {
+			message = fmt.Sprintf("image %s: %s", issue.Image, issue.Message)
+		}
+
+		location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{Uri: issue.File}}}
+		if issue.Line > 0 {
+			location.PhysicalLocation.Region = &sarifRegion{StartLine: issue.Line}
+		}
+
+		results = append(results, sarifResult{
+			RuleId:    issue.Rule,
+			Level:     level,
+			Message:   sarifMessage{Text: message},
+			Locations: []sarifLocation{location},
+		})
+	}
+
+	return sarifReport{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "cake",
+				InformationUri: "https://github.com/mesosphere/cake-builder",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+}
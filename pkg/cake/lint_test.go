@@ -0,0 +1,91 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestLintTemplates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	templates := map[string]string{
+		"root": `FROM ubuntu:{{ubuntu_version}}
+LABEL version={{version}} missing={{missing}}
+{{#packages}}RUN install {{name}} {{version}}{{/packages}}
+`,
+		"child": `FROM {{images.root.stable_tag}} AS builder
+FROM ubuntu
+RUN echo {{images.root.unknown}}
+`,
+		"gochild": `FROM {{ .parent }}
+{{ range .list }}RUN echo {{ .x }}{{ end }}
+RUN echo {{ .nope }} {{ index . "optional" }} {{ $.images.root.full_name }}
+`,
+	}
+	for name, template := range templates {
+		err = ioutil.WriteFile(path.Join(tmpDir, name), []byte(template), 0644)
+		if err != nil {
+			t.Errorf("Failed to write file: %v", err)
+		}
+	}
+
+	config := BuildConfig{
+		GlobalProperties: Properties{"version": "1.0", "unused_global": "value"},
+	}
+	images := map[string]*Image{
+		"root": {ImageConfig: ImageConfig{
+			Id:       "root",
+			Template: path.Join(tmpDir, "root"),
+			Properties: Properties{
+				"ubuntu_version": "18.04",
+				"packages":       []interface{}{map[string]interface{}{"name": "curl"}},
+				"list":           []interface{}{map[string]interface{}{"x": "1"}},
+			},
+		}},
+		"child": {ImageConfig: ImageConfig{
+			Id:         "child",
+			Parent:     "root",
+			Template:   path.Join(tmpDir, "child"),
+			Properties: Properties{"version": "1.0"},
+		}},
+		"gochild": {ImageConfig: ImageConfig{
+			Id:       "gochild",
+			Parent:   "root",
+			Template: path.Join(tmpDir, "gochild"),
+			Engine:   GoTemplateEngine,
+		}},
+	}
+	root, err := CreateImageBuildGraph(images)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	issues, err := LintTemplates(root, config, "cake.yaml")
+	if err != nil {
+		t.Errorf("Unexpected error while linting templates: %v", err)
+	}
+
+	expected := []string{
+		path.Join(tmpDir, "root") + ": [undefined-property] image root: 'missing' is not defined",
+		path.Join(tmpDir, "child") + ": [undefined-property] image child: 'images.root.unknown' is not defined",
+		path.Join(tmpDir, "child") + ":1: [parent-from] image child: the first FROM instruction doesn't use the parent image root",
+		path.Join(tmpDir, "gochild") + ": [undefined-property] image gochild: 'nope' is not defined",
+		"cake.yaml: [unused-property] global property 'unused_global' is not used in any template",
+		"cake.yaml: [unused-property] image child: property 'version' is not used in templates of the image or its descendants",
+		"cake.yaml: [shadowed-property] image child: property 'version' has the same value as the one inherited from global properties",
+	}
+	var found []string
+	for _, issue := range issues {
+		found = append(found, issue.String())
+	}
+	if !reflect.DeepEqual(expected, found) {
+		t.Errorf("Lint issues differ from the expected.\nExpected:\n%s\nFound:\n%s", expected, found)
+	}
+}
@@ -0,0 +1,134 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultLockFile = "cake.lock"
+
+const lockFileHeader = "# Generated by 'cake lock', do not edit manually\n"
+
+// LockFile records how images were resolved at the time they were reviewed, so a frozen build can verify
+// it builds exactly the same images
+type LockFile struct {
+	Images map[string]LockedImage `yaml:"images"`
+}
+
+// LockedImage is the resolution of a single image: the checksum calculated before any parent digests are known,
+// the digests of external base images if they are tracked and the digest of the parent if it was published
+type LockedImage struct {
+	Checksum     string            `yaml:"checksum"`
+	BaseDigests  map[string]string `yaml:"base_digests,omitempty"`
+	ParentDigest string            `yaml:"parent_digest,omitempty"`
+}
+
+func LoadLockFile(fileName string) (*LockFile, error) {
+	lock := &LockFile{Images: make(map[string]LockedImage)}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return lock, fmt.Errorf("error reading lock file: %v", err)
+	}
+	err = yaml.Unmarshal(content, lock)
+	if err != nil {
+		return lock, fmt.Errorf("cannot unmarshal lock file %s: %v", fileName, err)
+	}
+	if lock.Images == nil {
+		lock.Images = make(map[string]LockedImage)
+	}
+	return lock, nil
+}
+
+func (lock *LockFile) Write(fileName string) error {
+	content, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, append([]byte(lockFileHeader), content...), 0644)
+}
+
+// LockImages records checksums and base digests of rendered images and digests of their parents published
+// to the registry. Only images with the given IDs are updated unless the list is empty.
+func LockImages(dockerClient DockerClient, graph *Image, config BuildConfig, lock *LockFile, ids []string) error {
+	for _, id := range ids {
+		if findImage(graph, id) == nil {
+			return fmt.Errorf("image with ID '%s' is not defined in the config", id)
+		}
+	}
+
+	var err error
+	WalkBuildGraph(graph, func(image *Image) {
+		if err != nil || (len(ids) > 0 && !contains(ids, image.ImageConfig.Id)) {
+			return
+		}
+
+		locked := LockedImage{Checksum: image.Checksum, BaseDigests: image.BaseDigests}
+		if image.Parent != nil {
+			var exists bool
+			exists, err = ImageExists(dockerClient, image.Parent, config)
+			if err != nil {
+				return
+			}
+			if exists {
+				err = ResolveDigest(dockerClient, image.Parent, config)
+				if err != nil {
+					return
+				}
+				locked.ParentDigest = image.Parent.Digest
+			}
+		}
+		lock.Images[image.ImageConfig.Id] = locked
+	})
+	return err
+}
+
+// Verify fails if the checksum or the base digests of a rendered image differ from the locked ones
+func (lock *LockFile) Verify(image *Image) error {
+	locked, found := lock.Images[image.ImageConfig.Id]
+	if !found {
+		return fmt.Errorf("image %s is not locked, run 'cake lock --update %s'", image.ImageConfig.Id, image.ImageConfig.Id)
+	}
+
+	var differences []string
+	if locked.Checksum != image.Checksum {
+		differences = append(differences, fmt.Sprintf("checksum %s (locked %s)", image.Checksum, locked.Checksum))
+	}
+	if len(locked.BaseDigests) > 0 || len(image.BaseDigests) > 0 {
+		if !reflect.DeepEqual(locked.BaseDigests, image.BaseDigests) {
+			differences = append(differences, fmt.Sprintf("base digests %s (locked %s)",
+				formatDigests(image.BaseDigests), formatDigests(locked.BaseDigests)))
+		}
+	}
+	if len(differences) > 0 {
+		return fmt.Errorf("image %s differs from the lock file: %s", image.ImageConfig.Id, strings.Join(differences, ", "))
+	}
+	return nil
+}
+
+// VerifyParentDigest fails if the digest of the parent differs from the locked one. Parents which weren't published
+// when the image was locked are not verified.
+func (lock *LockFile) VerifyParentDigest(image *Image) error {
+	locked, found := lock.Images[image.ImageConfig.Id]
+	if !found || image.Parent == nil || len(locked.ParentDigest) == 0 || len(image.Parent.Digest) == 0 {
+		return nil
+	}
+	if locked.ParentDigest != image.Parent.Digest {
+		return fmt.Errorf("parent of image %s differs from the lock file: digest %s (locked %s)",
+			image.ImageConfig.Id, image.Parent.Digest, locked.ParentDigest)
+	}
+	return nil
+}
+
+func formatDigests(digests map[string]string) string {
+	var entries []string
+	for reference, digest := range digests {
+		entries = append(entries, reference+"@"+digest)
+	}
+	sort.Strings(entries)
+	return "[" + strings.Join(entries, " ") + "]"
+}
@@ -0,0 +1,93 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockImages(t *testing.T) {
+	root := &Image{
+		ImageConfig: ImageConfig{Id: "root", Repository: "repo", Name: "root"},
+		Checksum:    "aaa",
+		BaseDigests: map[string]string{"ubuntu:18.04": "sha256:111"},
+	}
+	child := &Image{ImageConfig: ImageConfig{Id: "child", Repository: "repo", Name: "child"}, Checksum: "bbb", Parent: root}
+	root.Children = []*Image{child}
+
+	dockerClient := &MockDockerClient{MockTagsResponse: []string{"aaa"}, MockManifestDigest: "sha256:222"}
+	lock := &LockFile{Images: map[string]LockedImage{"root": {Checksum: "old"}}}
+
+	err := LockImages(dockerClient, root, BuildConfig{}, lock, []string{"unknown"})
+	if err == nil {
+		t.Errorf("Expected an error for an unknown image")
+	}
+
+	err = LockImages(dockerClient, root, BuildConfig{}, lock, []string{"child"})
+	if err != nil {
+		t.Errorf("Unexpected error while locking images: %v", err)
+	}
+	expected := map[string]LockedImage{
+		"root":  {Checksum: "old"},
+		"child": {Checksum: "bbb", ParentDigest: "sha256:222"},
+	}
+	assert.Equal(t, expected, lock.Images)
+
+	err = LockImages(dockerClient, root, BuildConfig{}, lock, nil)
+	if err != nil {
+		t.Errorf("Unexpected error while locking images: %v", err)
+	}
+	expected["root"] = LockedImage{Checksum: "aaa", BaseDigests: map[string]string{"ubuntu:18.04": "sha256:111"}}
+	assert.Equal(t, expected, lock.Images)
+
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	lockFile := path.Join(dir, DefaultLockFile)
+	err = lock.Write(lockFile)
+	if err != nil {
+		t.Errorf("Unexpected error while writing lock file: %v", err)
+	}
+	loaded, err := LoadLockFile(lockFile)
+	if err != nil {
+		t.Errorf("Unexpected error while loading lock file: %v", err)
+	}
+	assert.Equal(t, lock, loaded)
+}
+
+func TestVerifyLock(t *testing.T) {
+	root := &Image{
+		ImageConfig: ImageConfig{Id: "root"},
+		Checksum:    "aaa",
+		BaseDigests: map[string]string{"ubuntu:18.04": "sha256:111"},
+	}
+	child := &Image{ImageConfig: ImageConfig{Id: "child"}, Checksum: "bbb", Parent: root}
+	lock := &LockFile{Images: map[string]LockedImage{
+		"root":  {Checksum: "aaa", BaseDigests: map[string]string{"ubuntu:18.04": "sha256:111"}},
+		"child": {Checksum: "bbb", ParentDigest: "sha256:222"},
+	}}
+
+	assert.NoError(t, lock.Verify(root))
+	assert.NoError(t, lock.Verify(child))
+	assert.NoError(t, lock.VerifyParentDigest(child), "unknown parent digests are not verified")
+
+	root.BaseDigests["ubuntu:18.04"] = "sha256:333"
+	assert.EqualError(t, lock.Verify(root),
+		"image root differs from the lock file: base digests [ubuntu:18.04@sha256:333] (locked [ubuntu:18.04@sha256:111])")
+
+	child.Checksum = "ccc"
+	assert.EqualError(t, lock.Verify(child), "image child differs from the lock file: checksum ccc (locked bbb)")
+
+	root.Digest = "sha256:444"
+	assert.EqualError(t, lock.VerifyParentDigest(child),
+		"parent of image child differs from the lock file: digest sha256:444 (locked sha256:222)")
+
+	delete(lock.Images, "child")
+	assert.Error(t, lock.Verify(child))
+}
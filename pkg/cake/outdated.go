@@ -0,0 +1,203 @@
+package cake
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Kinds of updates reported for external base images
+const (
+	TagUpdate    = "tag"
+	DigestUpdate = "digest"
+)
+
+// OutdatedBaseImage is an update available for an external base image of an image. Tag updates report the newest
+// tag of the same format as the current one, digest updates report a new digest of the current tag.
+type OutdatedBaseImage struct {
+	Image          string `json:"image"`
+	BaseImage      string `json:"base_image"`
+	Update         string `json:"update"`
+	Current        string `json:"current"`
+	Latest         string `json:"latest"`
+	Property       string `json:"property,omitempty"`
+	PropertySource string `json:"property_source,omitempty"`
+}
+
+var versionTagPattern = regexp.MustCompile(`^(v?)(\d+(?:\.\d+)*)(.*)$`)
+
+// versionTag is a tag starting with a version, e.g. '18.04' or '10.1-cudnn7-runtime'
+type versionTag struct {
+	prefix   string
+	version  string
+	numbers  []int
+	suffix   string
+	original string
+}
+
+func parseVersionTag(tag string) (versionTag, bool) {
+	match := versionTagPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return versionTag{}, false
+	}
+	parsed := versionTag{prefix: match[1], version: match[2], suffix: match[3], original: tag}
+	for _, number := range strings.Split(match[2], ".") {
+		value, err := strconv.Atoi(number)
+		if err != nil {
+			return versionTag{}, false
+		}
+		parsed.numbers = append(parsed.numbers, value)
+	}
+	return parsed, true
+}
+
+// sameFormat reports whether tags differ in version numbers only
+func (tag versionTag) sameFormat(other versionTag) bool {
+	return tag.prefix == other.prefix && tag.suffix == other.suffix && len(tag.numbers) == len(other.numbers)
+}
+
+func (tag versionTag) less(other versionTag) bool {
+	for i := range tag.numbers {
+		if tag.numbers[i] != other.numbers[i] {
+			return tag.numbers[i] < other.numbers[i]
+		}
+	}
+	return false
+}
+
+// newestTag returns the newest of the tags with the same format as the current tag if it's newer than the current one
+func newestTag(current string, tags []string) (string, bool) {
+	newest, ok := parseVersionTag(current)
+	if !ok {
+		return "", false
+	}
+	found := false
+	for _, tag := range tags {
+		candidate, ok := parseVersionTag(tag)
+		if ok && candidate.sameFormat(newest) && newest.less(candidate) {
+			newest = candidate
+			found = true
+		}
+	}
+	return newest.original, found
+}
+
+// versionProperty returns a version-like property of the image, i.e. a property with a name ending with 'version',
+// which defines the version of the tag
+func versionProperty(image *Image, tag versionTag) (ResolvedProperty, bool) {
+	if image.Properties == nil {
+		return ResolvedProperty{}, false
+	}
+	for _, property := range image.Properties.Properties() {
+		value, isString := property.Value.(string)
+		if isString && strings.HasSuffix(strings.ToLower(property.Name), "version") &&
+			(value == tag.original || value == tag.version) {
+			return property, true
+		}
+	}
+	return ResolvedProperty{}, false
+}
+
+// FindOutdatedBaseImages checks external base images of rendered images for newer tags in the registry
+// and for new digests of tags pinned by digest in the Dockerfile or in the lock file
+func FindOutdatedBaseImages(dockerClient DockerClient, graph *Image, lock *LockFile) ([]OutdatedBaseImage, error) {
+	cakeImages := make(map[string]bool)
+	WalkBuildGraph(graph, func(image *Image) {
+		cakeImages[image.getFullName()] = true
+	})
+
+	digests := make(map[string]string)
+	var outdated []OutdatedBaseImage
+	var err error
+	WalkBuildGraph(graph, func(image *Image) {
+		if err != nil {
+			return
+		}
+		var content []byte
+		content, err = ioutil.ReadFile(image.Dockerfile)
+		if err != nil {
+			return
+		}
+
+		for _, from := range baseImageReferences(parseDockerfile(string(content))) {
+			reference := from.reference
+			name, tag, digest := splitImageReference(reference)
+			if cakeImages[name] || len(tag) == 0 {
+				continue
+			}
+			if len(digest) == 0 && lock != nil {
+				digest = lock.Images[image.ImageConfig.Id].BaseDigests[reference]
+			}
+
+			var tags []string
+			tags, err = dockerClient.Tags(registryRepository(name))
+			if err != nil {
+				err = fmt.Errorf("unable to retrieve tags of base image %s of image %s: %v", reference, image.ImageConfig.Id, err)
+				return
+			}
+			if latest, found := newestTag(tag, tags); found {
+				update := OutdatedBaseImage{
+					Image:     image.ImageConfig.Id,
+					BaseImage: name,
+					Update:    TagUpdate,
+					Current:   tag,
+					Latest:    latest,
+				}
+				current, _ := parseVersionTag(tag)
+				if property, found := versionProperty(image, current); found {
+					update.Property = property.Name
+					update.PropertySource = property.Source
+				}
+				outdated = append(outdated, update)
+			}
+
+			if len(digest) == 0 {
+				continue
+			}
+			latest, resolved := digests[name+":"+tag]
+			if !resolved {
+				latest, err = dockerClient.ManifestDigest(registryRepository(name), tag)
+				if err != nil {
+					err = fmt.Errorf("unable to resolve digest of base image %s of image %s: %v", reference, image.ImageConfig.Id, err)
+					return
+				}
+				digests[name+":"+tag] = latest
+			}
+			if latest != digest {
+				outdated = append(outdated, OutdatedBaseImage{
+					Image:     image.ImageConfig.Id,
+					BaseImage: name + ":" + tag,
+					Update:    DigestUpdate,
+					Current:   digest,
+					Latest:    latest,
+				})
+			}
+		}
+	})
+	return outdated, err
+}
+
+// WriteOutdatedBaseImages writes available updates as a table or as JSON
+func WriteOutdatedBaseImages(writer io.Writer, outdated []OutdatedBaseImage, format string) error {
+	switch format {
+	case TextFormat:
+		tabs := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tabs, "IMAGE\tBASE IMAGE\tUPDATE\tCURRENT\tLATEST\tPROPERTY")
+		for _, update := range outdated {
+			fmt.Fprintf(tabs, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				update.Image, update.BaseImage, update.Update, update.Current, update.Latest, update.Property)
+		}
+		return tabs.Flush()
+	case JsonFormat:
+		if outdated == nil {
+			outdated = []OutdatedBaseImage{}
+		}
+		return writeJson(writer, outdated)
+	default:
+		return fmt.Errorf("unknown output format '%s', supported formats are: %s, %s", format, TextFormat, JsonFormat)
+	}
+}
@@ -0,0 +1,85 @@
+package cake
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewestTag(t *testing.T) {
+	tags := []string{"latest", "16.04", "18.04", "20.04", "20.10", "22.04-minimal", "v30.04", "10.1-cudnn7-runtime",
+		"10.2-cudnn7-runtime", "11.0-cudnn8-runtime", "1.2.3"}
+
+	tag, found := newestTag("18.04", tags)
+	assert.True(t, found)
+	assert.Equal(t, "20.10", tag)
+
+	tag, found = newestTag("10.1-cudnn7-runtime", tags)
+	assert.True(t, found)
+	assert.Equal(t, "10.2-cudnn7-runtime", tag)
+
+	_, found = newestTag("20.10", tags)
+	assert.False(t, found)
+	_, found = newestTag("latest", tags)
+	assert.False(t, found)
+}
+
+func TestFindOutdatedBaseImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outdated")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootDockerfile := path.Join(dir, "Dockerfile.root")
+	err = ioutil.WriteFile(rootDockerfile, []byte("FROM golang:1.13 AS builder\nFROM ubuntu:18.04\nCOPY --from=builder /app /app\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	childDockerfile := path.Join(dir, "Dockerfile.child")
+	err = ioutil.WriteFile(childDockerfile, []byte("FROM repo/root:abc\nFROM golang:1.15@sha256:111\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	properties := newPropertyScope()
+	properties.set("ubuntu_version", "18.04", "global properties")
+	root := &Image{ImageConfig: ImageConfig{Id: "root", Repository: "repo", Name: "root"}, Dockerfile: rootDockerfile, Properties: properties}
+	child := &Image{ImageConfig: ImageConfig{Id: "child", Repository: "repo", Name: "child"}, Dockerfile: childDockerfile, Parent: root}
+	root.Children = []*Image{child}
+
+	dockerClient := &MockDockerClient{
+		MockTagsByImage: map[string][]string{
+			"docker.io/library/golang": {"1.13", "1.15", "1.15-alpine"},
+			"docker.io/library/ubuntu": {"18.04", "20.04"},
+		},
+		MockManifestDigest: "sha256:222",
+	}
+	lock := &LockFile{Images: map[string]LockedImage{
+		"root": {BaseDigests: map[string]string{"ubuntu:18.04": "sha256:222"}},
+	}}
+
+	outdated, err := FindOutdatedBaseImages(dockerClient, root, lock)
+	if err != nil {
+		t.Errorf("Unexpected error while checking base images: %v", err)
+	}
+	expected := []OutdatedBaseImage{
+		{Image: "root", BaseImage: "golang", Update: TagUpdate, Current: "1.13", Latest: "1.15"},
+		{Image: "root", BaseImage: "ubuntu", Update: TagUpdate, Current: "18.04", Latest: "20.04",
+			Property: "ubuntu_version", PropertySource: "global properties"},
+		{Image: "child", BaseImage: "golang:1.15", Update: DigestUpdate, Current: "sha256:111", Latest: "sha256:222"},
+	}
+	assert.Equal(t, expected, outdated)
+	assert.Equal(t, []string{"docker.io/library/ubuntu:18.04", "docker.io/library/golang:1.15"}, dockerClient.ManifestRequests)
+
+	var output bytes.Buffer
+	err = WriteOutdatedBaseImages(&output, outdated[1:2], TextFormat)
+	if err != nil {
+		t.Errorf("Unexpected error while writing updates: %v", err)
+	}
+	assert.Equal(t, "IMAGE  BASE IMAGE  UPDATE  CURRENT  LATEST  PROPERTY\nroot   ubuntu      tag     18.04    20.04   ubuntu_version\n", output.String())
+}
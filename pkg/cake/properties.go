@@ -0,0 +1,201 @@
+package cake
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Properties is a map of template properties. Property values are strings, lists of values ([]interface{})
+// or nested maps of values (map[string]interface{}).
+type Properties map[string]interface{}
+
+// UnmarshalYAML keeps scalar property values as they are written in the config (e.g. '10.10' stays '10.10'
+// instead of becoming a float) and converts sequences and mappings to lists and nested maps
+func (properties *Properties) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var values map[string]propertyValue
+	err := unmarshal(&values)
+	if err != nil {
+		return err
+	}
+
+	*properties = make(Properties, len(values))
+	for name, value := range values {
+		(*properties)[name] = value.get()
+	}
+	return nil
+}
+
+type propertyValue struct {
+	value interface{}
+}
+
+func (property *propertyValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var scalar string
+	if err := unmarshal(&scalar); err == nil {
+		property.value = scalar
+		return nil
+	}
+
+	var list []propertyValue
+	if err := unmarshal(&list); err == nil {
+		values := make([]interface{}, len(list))
+		for i, item := range list {
+			values[i] = item.get()
+		}
+		property.value = values
+		return nil
+	}
+
+	var nested map[string]propertyValue
+	if err := unmarshal(&nested); err != nil {
+		return err
+	}
+	values := make(map[string]interface{}, len(nested))
+	for name, item := range nested {
+		values[name] = item.get()
+	}
+	property.value = values
+	return nil
+}
+
+// get returns the unmarshalled value, using an empty string for null values
+func (property propertyValue) get() interface{} {
+	if property.value == nil {
+		return ""
+	}
+	return property.value
+}
+
+// FormatPropertyValue returns string values as is and a canonical JSON representation of structured values
+func FormatPropertyValue(value interface{}) string {
+	if str, isString := value.(string); isString {
+		return str
+	}
+	// encoding/json sorts map keys which makes the output canonical
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
+
+// ResolvedProperty is a property value resolved for an image along with the layer it originates from
+type ResolvedProperty struct {
+	Name   string
+	Value  interface{}
+	Source string
+}
+
+// PropertyScope holds properties resolved for a single image. Every image gets its own scope so values
+// defined for one image never leak into another one.
+type PropertyScope struct {
+	values  map[string]interface{}
+	sources map[string]string
+}
+
+func newPropertyScope() *PropertyScope {
+	return &PropertyScope{
+		values:  make(map[string]interface{}),
+		sources: make(map[string]string),
+	}
+}
+
+func (scope *PropertyScope) set(name string, value interface{}, source string) {
+	scope.values[name] = value
+	scope.sources[name] = source
+}
+
+// Values returns a copy of resolved property values suitable for passing to a template engine
+func (scope *PropertyScope) Values() map[string]interface{} {
+	values := make(map[string]interface{}, len(scope.values))
+	for name, value := range scope.values {
+		values[name] = value
+	}
+	return values
+}
+
+// Properties returns resolved properties with their sources sorted by property name
+func (scope *PropertyScope) Properties() []ResolvedProperty {
+	properties := make([]ResolvedProperty, 0, len(scope.values))
+	for name, value := range scope.values {
+		properties = append(properties, ResolvedProperty{Name: name, Value: value, Source: scope.sources[name]})
+	}
+	sort.Slice(properties, func(i, j int) bool {
+		return properties[i].Name < properties[j].Name
+	})
+	return properties
+}
+
+// ResolveProperties builds a fresh property scope for the image by applying the following layers in order,
+// so that every next layer takes precedence over the previous ones:
+//   - global properties
+//   - properties of the image ancestors starting from the root image
+//   - properties of the image itself
+//   - overrides supplied for all images (--properties-file and --set flags)
+//   - overrides supplied for this specific image
+//
+// The 'parent' property is always resolved to the stable tag of the parent image and can't be overridden.
+func ResolveProperties(image *Image, config BuildConfig) *PropertyScope {
+	scope := newPropertyScope()
+
+	for name, value := range config.GlobalProperties {
+		scope.set(name, value, "global")
+	}
+
+	var ancestors []*Image
+	for ancestor := image.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		ancestors = append([]*Image{ancestor}, ancestors...)
+	}
+	for _, ancestor := range ancestors {
+		for name, value := range ancestor.ImageConfig.Properties {
+			scope.set(name, value, fmt.Sprintf("ancestor %s", ancestor.ImageConfig.Id))
+		}
+	}
+
+	for name, value := range image.ImageConfig.Properties {
+		scope.set(name, value, fmt.Sprintf("image %s", image.ImageConfig.Id))
+	}
+
+	for _, override := range config.PropertyOverrides {
+		if len(override.Image) == 0 {
+			scope.set(override.Key, override.Value, override.Source)
+		}
+	}
+	for _, override := range config.PropertyOverrides {
+		if len(override.Image) > 0 && override.Image == image.ImageConfig.Id {
+			scope.set(override.Key, override.Value, fmt.Sprintf("%s (%s)", override.Source, override.Image))
+		}
+	}
+
+	// the parent is pinned by digest once it's known, i.e. after the parent is pushed or found in the registry
+	if image.Parent != nil {
+		parent := image.Parent.StableReference(config)
+		if config.PinParentDigests && len(image.Parent.Digest) > 0 {
+			parent = fmt.Sprintf("%s@%s", parent, image.Parent.Digest)
+		}
+		scope.set("parent", parent, fmt.Sprintf("parent %s", image.Parent.ImageConfig.Id))
+	}
+
+	return scope
+}
+
+// structuredValues returns a canonical JSON serialization of all non-string property values in the scope
+// or an empty string if all the values are strings
+func (scope *PropertyScope) structuredValues() (string, error) {
+	structured := make(map[string]interface{})
+	for name, value := range scope.values {
+		if _, isString := value.(string); !isString {
+			structured[name] = value
+		}
+	}
+	if len(structured) == 0 {
+		return "", nil
+	}
+
+	out, err := json.Marshal(structured)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize structured property values: %v", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,103 @@
+package cake
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveProperties(t *testing.T) {
+	config := BuildConfig{
+		GlobalProperties: Properties{
+			"version": "global",
+			"os":      "ubuntu",
+		},
+		PropertyOverrides: []PropertyOverride{
+			{Image: "child", Key: "cuda", Value: "10.2", Source: "--set"},
+			{Key: "os", Value: "debian", Source: "overrides.yaml"},
+		},
+	}
+
+	root := &Image{ImageConfig: ImageConfig{
+		Id:         "root",
+		Repository: "repo",
+		Name:       "root",
+		Properties: Properties{"spark": "2.4.0", "version": "root"},
+	}}
+	child := &Image{
+		Parent: root,
+		ImageConfig: ImageConfig{
+			Id:         "child",
+			Properties: Properties{"version": "child", "cuda": "10.0"},
+		},
+	}
+	root.Children = []*Image{child}
+
+	expected := []ResolvedProperty{
+		{Name: "cuda", Value: "10.2", Source: "--set (child)"},
+		{Name: "os", Value: "debian", Source: "overrides.yaml"},
+		{Name: "parent", Value: "repo/root:latest", Source: "parent root"},
+		{Name: "spark", Value: "2.4.0", Source: "ancestor root"},
+		{Name: "version", Value: "child", Source: "image child"},
+	}
+
+	properties := ResolveProperties(child, config).Properties()
+	if !reflect.DeepEqual(expected, properties) {
+		t.Errorf("Resolved properties differ from the expected.\nExpected:\n%v\nFound:\n%v", expected, properties)
+	}
+
+	expectedRoot := map[string]interface{}{
+		"os":      "debian",
+		"spark":   "2.4.0",
+		"version": "root",
+	}
+
+	rootValues := ResolveProperties(root, config).Values()
+	if !reflect.DeepEqual(expectedRoot, rootValues) {
+		t.Errorf("Resolved properties differ from the expected.\nExpected:\n%v\nFound:\n%v", expectedRoot, rootValues)
+	}
+
+	expectedGlobal := Properties{"version": "global", "os": "ubuntu"}
+	if !reflect.DeepEqual(expectedGlobal, config.GlobalProperties) {
+		t.Errorf("Global properties were modified during resolution: %v", config.GlobalProperties)
+	}
+}
+
+func TestParentPinnedByDigest(t *testing.T) {
+	root := &Image{ImageConfig: ImageConfig{Id: "root", Repository: "repo", Name: "root"}, Checksum: "abc"}
+	child := &Image{Parent: root, ImageConfig: ImageConfig{Id: "child"}}
+	config := BuildConfig{ReleaseTag: "1.0", PinParentDigests: true}
+
+	//the parent is not pinned until its digest is known
+	if parent := ResolveProperties(child, config).Values()["parent"]; parent != "repo/root:1.0" {
+		t.Errorf("Expected parent without digest but found: %v", parent)
+	}
+
+	root.Digest = "sha256:0123456789abcdef"
+	if parent := ResolveProperties(child, config).Values()["parent"]; parent != "repo/root:1.0@sha256:0123456789abcdef" {
+		t.Errorf("Expected parent pinned by digest but found: %v", parent)
+	}
+
+	config.PinParentDigests = false
+	if parent := ResolveProperties(child, config).Values()["parent"]; parent != "repo/root:1.0" {
+		t.Errorf("Expected parent without digest when pinning is disabled but found: %v", parent)
+	}
+}
+
+func TestPropertyScopeIsolation(t *testing.T) {
+	config := BuildConfig{
+		GlobalProperties: Properties{"version": "global"},
+	}
+
+	first := &Image{ImageConfig: ImageConfig{Id: "first", Properties: Properties{"first_only": "value"}}}
+	second := &Image{ImageConfig: ImageConfig{Id: "second"}}
+
+	ResolveProperties(first, config)
+	values := ResolveProperties(second, config).Values()
+
+	if _, found := values["first_only"]; found {
+		t.Errorf("Property of one image leaked into the scope of another: %v", values)
+	}
+	if len(config.GlobalProperties) != 1 {
+		t.Errorf("Global properties were modified during resolution: %v", config.GlobalProperties)
+	}
+}
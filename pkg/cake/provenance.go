@@ -0,0 +1,278 @@
+package cake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Types and media types of in-toto statements with SLSA provenance
+const (
+	InTotoStatementType     = "https://in-toto.io/Statement/v0.1"
+	SlsaProvenanceType      = "https://slsa.dev/provenance/v0.2"
+	CakeBuildType           = "https://github.com/mesosphere/cake-builder/build@v1"
+	InTotoMediaType         = "application/vnd.in-toto+json"
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexMediaType       = "application/vnd.oci.image.index.v1+json"
+	ociEmptyConfigMediaType = "application/vnd.oci.empty.v1+json"
+)
+
+// ProvenanceStatement is an in-toto statement with a SLSA provenance predicate describing how an image was built
+type ProvenanceStatement struct {
+	Type          string              `json:"_type"`
+	PredicateType string              `json:"predicateType"`
+	Subject       []ProvenanceSubject `json:"subject"`
+	Predicate     SlsaProvenance      `json:"predicate"`
+}
+
+type ProvenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type SlsaProvenance struct {
+	Builder    SlsaBuilder          `json:"builder"`
+	BuildType  string               `json:"buildType"`
+	Invocation SlsaInvocation       `json:"invocation"`
+	Metadata   SlsaMetadata         `json:"metadata"`
+	Materials  []ProvenanceMaterial `json:"materials"`
+}
+
+type SlsaBuilder struct {
+	Id string `json:"id"`
+}
+
+type SlsaInvocation struct {
+	ConfigSource ProvenanceMaterial     `json:"configSource"`
+	Parameters   map[string]interface{} `json:"parameters"`
+}
+
+type SlsaMetadata struct {
+	BuildStartedOn  string `json:"buildStartedOn"`
+	BuildFinishedOn string `json:"buildFinishedOn"`
+}
+
+// ProvenanceMaterial is an artifact the image was built from, e.g. a file, the git revision or the parent image
+type ProvenanceMaterial struct {
+	Uri        string            `json:"uri"`
+	Digest     map[string]string `json:"digest,omitempty"`
+	EntryPoint string            `json:"entryPoint,omitempty"`
+}
+
+// NewProvenanceStatement describes the build of a pushed image: the digest of the pushed image is the subject,
+// the files used for the image checksum, the parent image and the git revision are the materials,
+// and the properties and build settings are the build parameters
+func NewProvenanceStatement(image *Image, config BuildConfig, started time.Time, finished time.Time) (ProvenanceStatement, error) {
+	if !strings.HasPrefix(image.Digest, "sha256:") {
+		return ProvenanceStatement{}, fmt.Errorf("image %s has no pushed digest to attest", image.ImageConfig.Id)
+	}
+
+	var materials []ProvenanceMaterial
+	configSource := ProvenanceMaterial{EntryPoint: "cake.yaml"}
+	if revision, err := gitRevision(config.BaseDir); err == nil {
+		source, err := gitOutput(config.BaseDir, "config", "--get", "remote.origin.url")
+		if err != nil {
+			source = config.BaseDir
+		}
+		configSource.Uri = "git+" + sanitizeRemoteUrl(source)
+		// uncommitted changes are not part of the commit, so it's not recorded as the digest of the sources
+		if !strings.HasSuffix(revision, DirtyRevisionSuffix) {
+			configSource.Digest = map[string]string{"sha1": revision}
+		}
+		materials = append(materials, ProvenanceMaterial{Uri: configSource.Uri, Digest: configSource.Digest})
+	}
+
+	if image.Parent != nil {
+		parent := ProvenanceMaterial{Uri: "pkg:docker/" + image.Parent.StableReference(config)}
+		if algorithm, hash, found := splitDigest(image.Parent.Digest); found {
+			parent.Digest = map[string]string{algorithm: hash}
+		}
+		materials = append(materials, parent)
+	}
+
+	var files []string
+	for file := range image.ChecksumInputs {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		path, err := config.contextPath(file)
+		if err != nil {
+			return ProvenanceStatement{}, err
+		}
+		materials = append(materials, ProvenanceMaterial{
+			Uri:    "file:" + filepath.ToSlash(path),
+			Digest: map[string]string{"sha256": image.ChecksumInputs[file]},
+		})
+	}
+
+	parameters := map[string]interface{}{
+		"image_id":    image.ImageConfig.Id,
+		"checksum":    image.Checksum,
+		"release_tag": config.ReleaseTag,
+	}
+	if image.Properties != nil {
+		parameters["properties"] = image.Properties.Values()
+	}
+	for name, value := range image.buildSettings() {
+		parameters[name] = value
+	}
+
+	algorithm, hash, _ := splitDigest(image.Digest)
+	return ProvenanceStatement{
+		Type:          InTotoStatementType,
+		PredicateType: SlsaProvenanceType,
+		Subject: []ProvenanceSubject{{
+			Name:   image.getFullName(),
+			Digest: map[string]string{algorithm: hash},
+		}},
+		Predicate: SlsaProvenance{
+			Builder:   SlsaBuilder{Id: fmt.Sprintf("https://github.com/mesosphere/cake-builder@%s", Version)},
+			BuildType: CakeBuildType,
+			Invocation: SlsaInvocation{
+				ConfigSource: configSource,
+				Parameters:   parameters,
+			},
+			Metadata: SlsaMetadata{
+				BuildStartedOn:  started.UTC().Format(time.RFC3339),
+				BuildFinishedOn: finished.UTC().Format(time.RFC3339),
+			},
+			Materials: materials,
+		},
+	}, nil
+}
+
+// splitDigest splits a digest in the form of '<algorithm>:<hash>'
+func splitDigest(value string) (string, string, bool) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// provenanceDirectory returns the directory provenance statements are written to, relative paths are resolved
+// against the base directory
+func (config BuildConfig) provenanceDirectory() string {
+	if filepath.IsAbs(config.ProvenanceDir) {
+		return config.ProvenanceDir
+	}
+	return filepath.Join(config.BaseDir, config.ProvenanceDir)
+}
+
+// RecordProvenance creates the provenance statement of a pushed image, writes it to the provenance directory
+// as '<image id>.intoto.json' and attaches it to the image in the registry if configured
+func RecordProvenance(dockerClient *ExternalDockerClient, image *Image, config BuildConfig, started time.Time, finished time.Time) error {
+	statement, err := NewProvenanceStatement(image, config, started, finished)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize provenance of image %s: %v", image.ImageConfig.Id, err)
+	}
+
+	directory := config.provenanceDirectory()
+	err = os.MkdirAll(directory, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create provenance directory: %v", err)
+	}
+	file := filepath.Join(directory, image.ImageConfig.Id+".intoto.json")
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write provenance of image %s: %v", image.ImageConfig.Id, err)
+	}
+	image.Provenance = &statement
+	image.ProvenanceFile = file
+	log.Printf("Provenance of image %s written to %s", image.ImageConfig.Id, file)
+
+	if config.AttachProvenance {
+		return attachProvenance(dockerClient, image, content)
+	}
+	return nil
+}
+
+// artifactManifest is an OCI manifest of an artifact referring to an image via the subject field
+type artifactManifest struct {
+	SchemaVersion int                       `json:"schemaVersion"`
+	MediaType     string                    `json:"mediaType"`
+	ArtifactType  string                    `json:"artifactType"`
+	Config        distribution.Descriptor   `json:"config"`
+	Layers        []distribution.Descriptor `json:"layers"`
+	Subject       distribution.Descriptor   `json:"subject"`
+}
+
+func (manifest *artifactManifest) References() []distribution.Descriptor {
+	return append([]distribution.Descriptor{manifest.Config}, manifest.Layers...)
+}
+
+func (manifest *artifactManifest) Payload() (string, []byte, error) {
+	payload, err := json.Marshal(manifest)
+	return manifest.MediaType, payload, err
+}
+
+// attachProvenance pushes the provenance statement as an OCI artifact referring to the image. Registries supporting
+// the referrers API list it as a referrer of the image, the artifact is also tagged with 'sha256-<hash>.provenance'.
+func attachProvenance(dockerClient *ExternalDockerClient, image *Image, statement []byte) error {
+	dockerRegistry, name, err := dockerClient.RegistryFor(image.getFullName())
+	if err != nil {
+		return err
+	}
+	subject, err := dockerRegistry.ManifestV2(name, image.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of image %s: %v", image.ImageConfig.Id, err)
+	}
+	subjectMediaType, subjectPayload, err := subject.Payload()
+	if err != nil {
+		return err
+	}
+
+	emptyConfig := []byte("{}")
+	manifest := &artifactManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		ArtifactType:  InTotoMediaType,
+		Config:        blobDescriptor(ociEmptyConfigMediaType, emptyConfig),
+		Layers:        []distribution.Descriptor{blobDescriptor(InTotoMediaType, statement)},
+		Subject: distribution.Descriptor{
+			MediaType: subjectMediaType,
+			Digest:    digest.Digest(image.Digest),
+			Size:      int64(len(subjectPayload)),
+		},
+	}
+
+	for _, blob := range [][]byte{emptyConfig, statement} {
+		err = dockerRegistry.UploadBlob(name, digest.FromBytes(blob), bytes.NewReader(blob))
+		if err != nil {
+			return fmt.Errorf("failed to upload provenance of image %s: %v", image.ImageConfig.Id, err)
+		}
+	}
+
+	algorithm, hash, _ := splitDigest(image.Digest)
+	tag := fmt.Sprintf("%s-%s.provenance", algorithm, hash)
+	err = dockerRegistry.PutManifest(name, tag, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to push provenance of image %s: %v", image.ImageConfig.Id, err)
+	}
+	log.Printf("Provenance of image %s attached as %s:%s", image.ImageConfig.Id, image.getFullName(), tag)
+	return nil
+}
+
+func blobDescriptor(mediaType string, content []byte) distribution.Descriptor {
+	return distribution.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+}
@@ -0,0 +1,97 @@
+package cake
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordProvenance(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "base")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	templateFile := path.Join(baseDir, "app", "Dockerfile.template")
+	err = os.MkdirAll(path.Dir(templateFile), os.ModePerm)
+	if err != nil {
+		t.Errorf("Failed to create directory: %v", err)
+	}
+	err = ioutil.WriteFile(templateFile, []byte("FROM {{parent}}\nARG VERSION\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	parent := &Image{ImageConfig: ImageConfig{Id: "base", Repository: "repo", Name: "base"}, Checksum: "abc", Digest: "sha256:123"}
+	image := &Image{
+		ImageConfig: ImageConfig{
+			Id:         "app",
+			Repository: "repo",
+			Name:       "app",
+			Template:   templateFile,
+			BuildArgs:  map[string]string{"VERSION": "1.0"},
+			Properties: Properties{"version": "1.0"},
+		},
+		Parent: parent,
+	}
+	config := BuildConfig{BaseDir: baseDir, ProvenanceDir: DefaultProvenanceDir}
+
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+
+	started := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	err = RecordProvenance(nil, image, config, started, started.Add(time.Minute))
+	if err == nil {
+		t.Errorf("Expected an error for an image without a pushed digest")
+	}
+
+	image.Digest = "sha256:456"
+	err = RecordProvenance(nil, image, config, started, started.Add(time.Minute))
+	if err != nil {
+		t.Errorf("Unexpected error while recording provenance: %v", err)
+	}
+
+	expectedFile := path.Join(baseDir, ".cake/provenance/app.intoto.json")
+	assert.Equal(t, expectedFile, image.ProvenanceFile)
+	content, err := ioutil.ReadFile(expectedFile)
+	if err != nil {
+		t.Errorf("Unable to read file: %v", err)
+	}
+
+	var statement ProvenanceStatement
+	err = json.Unmarshal(content, &statement)
+	if err != nil {
+		t.Errorf("Failed to parse provenance statement: %v", err)
+	}
+
+	assert.Equal(t, InTotoStatementType, statement.Type)
+	assert.Equal(t, SlsaProvenanceType, statement.PredicateType)
+	assert.Equal(t, []ProvenanceSubject{{Name: "repo/app", Digest: map[string]string{"sha256": "456"}}}, statement.Subject)
+	assert.Equal(t, "2020-03-01T10:01:00Z", statement.Predicate.Metadata.BuildFinishedOn)
+
+	parameters := statement.Predicate.Invocation.Parameters
+	assert.Equal(t, "app", parameters["image_id"])
+	assert.Equal(t, image.Checksum, parameters["checksum"])
+	assert.Equal(t, map[string]interface{}{"version": "1.0", "parent": "repo/base:abc"}, parameters["properties"])
+	assert.Equal(t, map[string]interface{}{"VERSION": "1.0"}, parameters["build_args"])
+
+	materials := make(map[string]map[string]string)
+	for _, material := range statement.Predicate.Materials {
+		materials[material.Uri] = material.Digest
+	}
+	assert.Equal(t, map[string]string{"sha256": "123"}, materials["pkg:docker/repo/base:abc"])
+	assert.Equal(t, map[string]string{"sha256": image.ChecksumInputs[templateFile]}, materials["file:app/Dockerfile.template"])
+	assert.Contains(t, materials, "file:.cake/generated/app/Dockerfile")
+}
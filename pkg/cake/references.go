@@ -0,0 +1,187 @@
+package cake
+
+import (
+	"fmt"
+	"strings"
+	"text/template/parse"
+
+	"github.com/cbroglie/mustache"
+)
+
+// referencedImages returns IDs of images referenced from the image template (including the partials it uses)
+// via the 'images' namespace or the 'image' function of Go templates. Plain Dockerfiles can't reference images.
+func (image *Image) referencedImages(config BuildConfig) ([]string, error) {
+	if image.isPlainDockerfile() {
+		return nil, nil
+	}
+
+	// properties are not resolved at this point, so the context is used only for parsing
+	context := &templateContext{image: image, config: config, engine: templateEngine(image, config)}
+	templateFile := image.ImageConfig.Template
+
+	var references []string
+	addReference := func(id string) {
+		if !contains(references, id) {
+			references = append(references, id)
+		}
+	}
+
+	switch context.engine {
+	case MustacheEngine:
+		provider := &partialProvider{context: context, directory: context.partialsDir(templateFile)}
+		tmpl, err := mustache.ParseFilePartials(templateFile, provider)
+		if err != nil {
+			return nil, err
+		}
+		err = walkMustacheTags(tmpl.Tags(), provider, func(tag mustache.Tag) {
+			path := strings.Split(tag.Name(), ".")
+			if (tag.Type() == mustache.Variable || tag.Type() == mustache.Section || tag.Type() == mustache.InvertedSection) &&
+				len(path) > 1 && path[0] == "images" {
+				addReference(path[1])
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	case GoTemplateEngine:
+		tmpl, _, err := parseGoTemplate(templateFile, context.partialsDir(templateFile), context.funcs())
+		if err != nil {
+			return nil, err
+		}
+		for _, included := range tmpl.Templates() {
+			if included.Tree == nil {
+				continue
+			}
+			walkGoTemplate(included.Tree.Root, func(node parse.Node) {
+				switch typed := node.(type) {
+				case *parse.FieldNode:
+					if len(typed.Ident) > 1 && typed.Ident[0] == "images" {
+						addReference(typed.Ident[1])
+					}
+				case *parse.VariableNode:
+					if len(typed.Ident) > 2 && typed.Ident[0] == "$" && typed.Ident[1] == "images" {
+						addReference(typed.Ident[2])
+					}
+				case *parse.CommandNode:
+					if id, found := goTemplateImageArgument(typed); found {
+						addReference(id)
+					}
+				}
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown template engine '%s' for image %s", context.engine, image.ImageConfig.Id)
+	}
+	return references, nil
+}
+
+// goTemplateImageArgument returns an image ID from commands in the form of 'image "<id>"' and 'index .images "<id>"'
+func goTemplateImageArgument(command *parse.CommandNode) (string, bool) {
+	if len(command.Args) < 2 {
+		return "", false
+	}
+	function, isIdentifier := command.Args[0].(*parse.IdentifierNode)
+	if !isIdentifier {
+		return "", false
+	}
+
+	if function.Ident == "image" {
+		if id, isString := command.Args[1].(*parse.StringNode); isString {
+			return id.Text, true
+		}
+	}
+	if function.Ident == "index" && len(command.Args) > 2 {
+		field, isField := command.Args[1].(*parse.FieldNode)
+		id, isString := command.Args[2].(*parse.StringNode)
+		if isField && isString && len(field.Ident) == 1 && field.Ident[0] == "images" {
+			return id.Text, true
+		}
+	}
+	return "", false
+}
+
+// walkMustacheTags applies the function to all tags of a mustache template including the tags nested in sections
+// and the tags of the partials it uses
+func walkMustacheTags(tags []mustache.Tag, provider mustache.PartialProvider, visit func(tag mustache.Tag)) error {
+	visited := make(map[string]bool)
+	var walk func(tags []mustache.Tag) error
+	walk = func(tags []mustache.Tag) error {
+		for _, tag := range tags {
+			visit(tag)
+			switch tag.Type() {
+			case mustache.Section, mustache.InvertedSection:
+				if err := walk(tag.Tags()); err != nil {
+					return err
+				}
+			case mustache.Partial:
+				if visited[tag.Name()] {
+					continue
+				}
+				visited[tag.Name()] = true
+				content, err := provider.Get(tag.Name())
+				if err != nil {
+					return err
+				}
+				partial, err := mustache.ParseStringPartials(content, provider)
+				if err != nil {
+					return err
+				}
+				if err := walk(partial.Tags()); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(tags)
+}
+
+// walkGoTemplate applies the function to all nodes of a Go template parse tree
+func walkGoTemplate(node parse.Node, visit func(node parse.Node)) {
+	if node == nil {
+		return
+	}
+	visit(node)
+
+	switch typed := node.(type) {
+	case *parse.ListNode:
+		if typed == nil {
+			return
+		}
+		for _, child := range typed.Nodes {
+			walkGoTemplate(child, visit)
+		}
+	case *parse.ActionNode:
+		walkGoTemplate(typed.Pipe, visit)
+	case *parse.IfNode:
+		walkBranch(&typed.BranchNode, visit)
+	case *parse.RangeNode:
+		walkBranch(&typed.BranchNode, visit)
+	case *parse.WithNode:
+		walkBranch(&typed.BranchNode, visit)
+	case *parse.TemplateNode:
+		walkGoTemplate(typed.Pipe, visit)
+	case *parse.PipeNode:
+		if typed == nil {
+			return
+		}
+		for _, variable := range typed.Decl {
+			walkGoTemplate(variable, visit)
+		}
+		for _, command := range typed.Cmds {
+			walkGoTemplate(command, visit)
+		}
+	case *parse.CommandNode:
+		for _, arg := range typed.Args {
+			walkGoTemplate(arg, visit)
+		}
+	case *parse.ChainNode:
+		walkGoTemplate(typed.Node, visit)
+	}
+}
+
+func walkBranch(branch *parse.BranchNode, visit func(node parse.Node)) {
+	walkGoTemplate(branch.Pipe, visit)
+	walkGoTemplate(branch.List, visit)
+	walkGoTemplate(branch.ElseList, visit)
+}
@@ -0,0 +1,202 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+// splitRegistryHost splits an image name into the registry host and the repository path. Like in Docker, the first
+// component of the name is a host only if it contains a dot or a port or is 'localhost', e.g. 'quay.io/org/image'.
+func splitRegistryHost(name string) (string, string) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0], parts[1]
+	}
+	return "", name
+}
+
+// registryUrl returns the URL of the registry API of the host, registries on localhost are accessed via plain HTTP
+func registryUrl(host string) string {
+	if host == "localhost" || strings.HasPrefix(host, "localhost:") || strings.HasPrefix(host, "127.0.0.1") {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// RegistryFor returns the registry client of the host of the image and the repository path within the registry.
+// Images without a host and images on the host of the configured registry use the configured registry client.
+// Clients are created on demand, credentials which are not configured explicitly are read from the Docker config.
+func (client *ExternalDockerClient) RegistryFor(imageName string) (*registry.Registry, string, error) {
+	host, path := splitRegistryHost(imageName)
+	client.registriesLock.Lock()
+	defer client.registriesLock.Unlock()
+
+	if len(host) == 0 || registryHost(host) == registryHost(client.AuthConfig.DockerRegistryUrl) {
+		if client.Registry == nil {
+			authConfig, err := ResolveCredentials(client.AuthConfig)
+			if err != nil {
+				return nil, path, err
+			}
+			dockerRegistry, err := registry.New(authConfig.DockerRegistryUrl, authConfig.Username, authConfig.Password)
+			if err != nil {
+				return nil, path, fmt.Errorf("unable to connect to registry %s: %v", authConfig.DockerRegistryUrl, err)
+			}
+			client.Registry = dockerRegistry
+		}
+		return client.Registry, path, nil
+	}
+	host = registryHost(host)
+
+	if dockerRegistry, found := client.registries[host]; found {
+		return dockerRegistry, path, nil
+	}
+
+	authConfig, err := ResolveCredentials(AuthConfig{DockerRegistryUrl: registryUrl(host)})
+	if err != nil {
+		return nil, path, err
+	}
+	dockerRegistry, err := registry.New(authConfig.DockerRegistryUrl, authConfig.Username, authConfig.Password)
+	if err != nil {
+		return nil, path, fmt.Errorf("unable to connect to registry %s: %v", host, err)
+	}
+	client.registries[host] = dockerRegistry
+	return dockerRegistry, path, nil
+}
+
+// ResolveRegistryAuths returns credentials of registries used by images of the build graph by registry host: registries
+// the images are pushed to and registries hosting external base images of rendered Dockerfiles. Credentials of other
+// registries are not looked up. The credentials of the configured registry given explicitly take precedence over
+// the Docker config.
+func ResolveRegistryAuths(authConfig AuthConfig, graph *Image) (map[string]AuthConfig, error) {
+	hosts, err := registryHosts(graph)
+	if err != nil {
+		return nil, err
+	}
+	dockerConfig, err := LoadDockerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	auths := make(map[string]AuthConfig)
+	if len(authConfig.Username) == 0 && len(authConfig.Password) == 0 && usesConfiguredRegistry(graph) {
+		authConfig.Username, authConfig.Password, err = dockerConfig.Credentials(authConfig.DockerRegistryUrl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(authConfig.Username) > 0 || len(authConfig.Password) > 0 {
+		auths[registryHost(authConfig.DockerRegistryUrl)] = authConfig
+	}
+	for _, host := range hosts {
+		if _, found := auths[host]; found {
+			continue
+		}
+		username, password, err := dockerConfig.Credentials(host)
+		if err != nil {
+			return nil, err
+		}
+		if len(username) > 0 || len(password) > 0 {
+			auths[host] = AuthConfig{DockerRegistryUrl: host, Username: username, Password: password}
+		}
+	}
+	return auths, nil
+}
+
+// usesConfiguredRegistry returns true if any image of the build graph is pushed to the configured registry
+// because its name has no registry host
+func usesConfiguredRegistry(graph *Image) bool {
+	found := false
+	WalkBuildGraph(graph, func(image *Image) {
+		if host, _ := splitRegistryHost(image.getFullName()); len(host) == 0 {
+			found = true
+		}
+	})
+	return found
+}
+
+// registryHosts returns sorted hosts of registries images of the build graph are pushed to, except for images without
+// a host which use the configured registry, and hosts of external base images referenced in rendered Dockerfiles
+func registryHosts(graph *Image) ([]string, error) {
+	cakeImages := make(map[string]bool)
+	WalkBuildGraph(graph, func(image *Image) {
+		cakeImages[image.getFullName()] = true
+	})
+
+	var hosts []string
+	var err error
+	addHost := func(name string) {
+		if host, _ := splitRegistryHost(name); len(host) > 0 && !contains(hosts, registryHost(host)) {
+			hosts = append(hosts, registryHost(host))
+		}
+	}
+	WalkBuildGraph(graph, func(image *Image) {
+		if err != nil {
+			return
+		}
+		addHost(image.getFullName())
+		if len(image.Dockerfile) == 0 {
+			return
+		}
+		var content []byte
+		content, err = ioutil.ReadFile(image.Dockerfile)
+		if err != nil {
+			err = fmt.Errorf("failed to read rendered Dockerfile of image %s: %v", image.ImageConfig.Id, err)
+			return
+		}
+		for _, from := range baseImageReferences(parseDockerfile(string(content))) {
+			if name, _, _ := splitImageReference(from.reference); !cakeImages[name] {
+				addHost(registryRepository(name))
+			}
+		}
+	})
+	sort.Strings(hosts)
+	return hosts, err
+}
+
+// sortedRegistryAuths returns credentials of registries ordered by registry host
+func sortedRegistryAuths(auths map[string]AuthConfig) []AuthConfig {
+	var hosts []string
+	for host := range auths {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var sorted []AuthConfig
+	for _, host := range hosts {
+		sorted = append(sorted, auths[host])
+	}
+	return sorted
+}
+
+// registryAuthConfig returns the credentials of the registry hosting the image
+func (config BuildConfig) registryAuthConfig(imageName string) AuthConfig {
+	host, _ := splitRegistryHost(imageName)
+	if len(host) == 0 || registryHost(host) == registryHost(config.AuthConfig.DockerRegistryUrl) {
+		return config.configuredRegistryAuthConfig()
+	}
+	return config.RegistryAuths[registryHost(host)]
+}
+
+// configuredRegistryAuthConfig returns the credentials of the configured registry given explicitly or, if there are
+// none, the ones resolved from the Docker config by ResolveRegistryAuths
+func (config BuildConfig) configuredRegistryAuthConfig() AuthConfig {
+	if len(config.AuthConfig.Username) > 0 || len(config.AuthConfig.Password) > 0 {
+		return config.AuthConfig
+	}
+	if authConfig, found := config.RegistryAuths[registryHost(config.AuthConfig.DockerRegistryUrl)]; found {
+		return authConfig
+	}
+	return config.AuthConfig
+}
+
+// serverAddress returns the registry address Docker daemon expects credentials for the registry to be stored under
+func (authConfig AuthConfig) serverAddress() string {
+	if registryHost(authConfig.DockerRegistryUrl) == "index.docker.io" {
+		return dockerHubServer
+	}
+	return authConfig.DockerRegistryUrl
+}
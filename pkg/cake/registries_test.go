@@ -0,0 +1,96 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitRegistryHost(t *testing.T) {
+	cases := map[string][]string{
+		"quay.io/org/image":          {"quay.io", "org/image"},
+		"localhost:5000/image":       {"localhost:5000", "image"},
+		"localhost/org/image":        {"localhost", "org/image"},
+		"org/image":                  {"", "org/image"},
+		"image":                      {"", "image"},
+		"docker.io/library/ubuntu":   {"docker.io", "library/ubuntu"},
+		"registry.example.com:443/x": {"registry.example.com:443", "x"},
+	}
+	for name, expected := range cases {
+		host, path := splitRegistryHost(name)
+		assert.Equal(t, expected, []string{host, path}, name)
+	}
+}
+
+func TestRegistryRepository(t *testing.T) {
+	assert.Equal(t, "docker.io/library/ubuntu", registryRepository("ubuntu"))
+	assert.Equal(t, "docker.io/nvidia/cuda", registryRepository("nvidia/cuda"))
+	assert.Equal(t, "docker.io/library/ubuntu", registryRepository("docker.io/ubuntu"))
+	assert.Equal(t, "quay.io/org/base", registryRepository("quay.io/org/base"))
+	assert.Equal(t, "localhost:5000/base", registryRepository("localhost:5000/base"))
+}
+
+func TestMultipleRegistryAuths(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "base")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	quayAuth := AuthConfig{DockerRegistryUrl: "quay.io", Username: "robot", Password: "quay-secret"}
+	buildConfig := BuildConfig{
+		BaseDir: baseDir,
+		AuthConfig: AuthConfig{
+			DockerRegistryUrl: "https://index.docker.io",
+			Username:          "user",
+			Password:          "password",
+		},
+		RegistryAuths: map[string]AuthConfig{
+			"quay.io":         quayAuth,
+			"index.docker.io": {DockerRegistryUrl: "https://index.docker.io/v1/", Username: "hub", Password: "hub-secret"},
+		},
+	}
+	image := &Image{
+		ImageConfig: ImageConfig{Repository: "quay.io/org", Name: "image"},
+		Dockerfile:  baseDir + "/Dockerfile",
+		Checksum:    "abc",
+	}
+
+	dockerClient := new(MockDockerClient)
+	err = BuildImage(dockerClient, image, buildConfig)
+	if err != nil {
+		t.Error(err)
+	}
+
+	authConfigs := dockerClient.ImageBuildOptions.AuthConfigs
+	assert.Len(t, authConfigs, 2)
+	assert.Equal(t, types.AuthConfig{Username: "robot", Password: "quay-secret", ServerAddress: "quay.io"}, authConfigs["quay.io"])
+	// credentials of the configured registry take precedence over the ones from the Docker config
+	assert.Equal(t, types.AuthConfig{Username: "user", Password: "password", ServerAddress: dockerHubServer}, authConfigs[dockerHubServer])
+
+	// images are pushed with the credentials of the registry hosting them
+	err = PushImage(dockerClient, image, buildConfig)
+	if err != nil {
+		t.Error(err)
+	}
+	expectedAuth, err := encodeAuth(quayAuth)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, expectedAuth, dockerClient.ImagePushOptions.RegistryAuth)
+	assert.Contains(t, dockerClient.ImagePushTags, "quay.io/org/image:abc")
+
+	image.ImageConfig.Repository = "org"
+	err = PushImage(dockerClient, image, buildConfig)
+	if err != nil {
+		t.Error(err)
+	}
+	expectedAuth, err = encodeAuth(buildConfig.AuthConfig)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, expectedAuth, dockerClient.ImagePushOptions.RegistryAuth)
+}
@@ -6,14 +6,23 @@ import (
 	"io/ioutil"
 )
 
+// Output formats of commands printing reports
+const (
+	TextFormat = "text"
+	JsonFormat = "json"
+)
+
 type BuildReport struct {
 	Images []ImageBuildSummary
 }
 
 type ImageBuildSummary struct {
-	Id            string
-	StableTag     string
-	PublishedTags []string
+	Id             string
+	StableTag      string
+	PublishedTags  []string
+	Digest         string               `json:",omitempty"`
+	ProvenanceFile string               `json:",omitempty"`
+	Provenance     *ProvenanceStatement `json:",omitempty"`
 }
 
 func GenerateReport(image *Image, config BuildConfig) error {
@@ -21,9 +30,12 @@ func GenerateReport(image *Image, config BuildConfig) error {
 
 	WalkBuildGraph(image, func(image *Image) {
 		summary := ImageBuildSummary{
-			Id:            image.ImageConfig.Id,
-			StableTag:     fmt.Sprintf("%s:%s", image.getFullName(), image.getStableTag(config)),
-			PublishedTags: image.getDockerTags(config),
+			Id:             image.ImageConfig.Id,
+			StableTag:      fmt.Sprintf("%s:%s", image.getFullName(), image.getStableTag(config)),
+			PublishedTags:  image.getDockerTags(config),
+			Digest:         image.Digest,
+			ProvenanceFile: image.ProvenanceFile,
+			Provenance:     image.Provenance,
 		}
 
 		summaries = append(summaries, summary)
@@ -0,0 +1,137 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// revisionPattern matches full commit IDs of SHA-1 and SHA-256 git repositories
+var revisionPattern = regexp.MustCompile("^([0-9a-f]{40}|[0-9a-f]{64})$")
+
+// CheckoutWorktree checks out the revision of the git repository containing the project directory into a temporary
+// worktree. It returns the project directory within the worktree and a function removing the worktree. Revisions of
+// working trees with uncommitted changes are refused since the changes can't be restored.
+func CheckoutWorktree(baseDir string, revision string) (string, func(), error) {
+	if strings.HasSuffix(revision, DirtyRevisionSuffix) {
+		return "", nil, fmt.Errorf("revision %s contains uncommitted changes and can't be checked out", revision)
+	}
+	// the revision comes from image labels, so it must not be interpreted as an option of git
+	if !revisionPattern.MatchString(revision) {
+		return "", nil, fmt.Errorf("revision '%s' is not a full SHA-1 or SHA-256 commit ID", revision)
+	}
+	prefix, err := gitOutput(baseDir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", nil, fmt.Errorf("%s is not a git repository: %v", baseDir, err)
+	}
+
+	directory, err := ioutil.TempDir("", "cake-reproduce-")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := gitOutput(baseDir, "worktree", "add", "--detach", directory, revision); err != nil {
+		os.RemoveAll(directory)
+		return "", nil, fmt.Errorf("failed to check out revision %s: %v", revision, err)
+	}
+
+	cleanup := func() {
+		gitOutput(baseDir, "worktree", "remove", "--force", directory)
+		os.RemoveAll(directory)
+	}
+	return filepath.Join(directory, prefix), cleanup, nil
+}
+
+// ReproductionOverrides returns overrides restoring the properties recorded in the labels of the image and
+// its ancestors, so the images are rendered exactly as they were regardless of overrides used for the original build
+func ReproductionOverrides(chain []ImageLineage) []PropertyOverride {
+	var overrides []PropertyOverride
+	for _, lineage := range chain {
+		for _, name := range sortedNames(lineage.Properties) {
+			// the parent is resolved from the build graph
+			if name == "parent" {
+				continue
+			}
+			overrides = append(overrides, PropertyOverride{
+				Image:  lineage.ImageId,
+				Key:    name,
+				Value:  lineage.Properties[name],
+				Source: fmt.Sprintf("labels of %s", lineage.Reference),
+			})
+		}
+	}
+	return overrides
+}
+
+// RestoreParentDigests sets digests of parents recorded in the labels of the image and its ancestors, so they are
+// rendered and labeled the same way as in the original build. It returns true if parents were pinned by digest in the
+// original build, i.e. the recorded parent property references the parent by digest.
+func RestoreParentDigests(chain []ImageLineage, images map[string]*Image) bool {
+	pinned := false
+	for _, lineage := range chain {
+		image, found := images[lineage.ImageId]
+		if !found || image.Parent == nil || len(lineage.ParentDigest) == 0 {
+			continue
+		}
+		image.Parent.Digest = lineage.ParentDigest
+		if parent, ok := lineage.Properties["parent"].(string); ok && strings.HasSuffix(parent, "@"+lineage.ParentDigest) {
+			pinned = true
+		}
+	}
+	return pinned
+}
+
+// RestoreBaseDigests sets digests of external base images recorded in the labels of the image and its ancestors, so
+// root images are rendered with the base images used in the original build instead of the current ones
+func RestoreBaseDigests(chain []ImageLineage, images map[string]*Image) {
+	for _, lineage := range chain {
+		image, found := images[lineage.ImageId]
+		if !found || len(lineage.BaseDigests) == 0 {
+			continue
+		}
+		image.BaseDigests = make(map[string]string)
+		for reference, digest := range lineage.BaseDigests {
+			image.BaseDigests[reference] = digest
+		}
+	}
+}
+
+// ReleaseTagFromReference returns the release tag the image was published with. Images published with checksum tags
+// only, or referenced by digest, were built without a release tag, so 'latest' is returned for them.
+func ReleaseTagFromReference(image *Image, reference string, checksum string) string {
+	_, tag := SplitImageReference(reference)
+	if strings.Contains(reference, "@") {
+		return "latest"
+	}
+	if len(image.ImageConfig.TagPrefix) > 0 {
+		tag = strings.TrimPrefix(tag, image.ImageConfig.TagPrefix+"-")
+	}
+	if len(image.ImageConfig.TagSuffix) > 0 {
+		tag = strings.TrimSuffix(tag, "-"+image.ImageConfig.TagSuffix)
+	}
+	if tag == checksum {
+		return "latest"
+	}
+	return tag
+}
+
+// ReproductionReport compares a published image with the image rebuilt from the recorded revision
+type ReproductionReport struct {
+	Image             string
+	ImageId           string
+	Revision          string
+	PublishedChecksum string
+	Checksum          string
+	PublishedDigest   string
+	Digest            string
+}
+
+func (report ReproductionReport) ChecksumMatches() bool {
+	return report.PublishedChecksum == report.Checksum
+}
+
+func (report ReproductionReport) DigestMatches() bool {
+	return len(report.Digest) > 0 && report.PublishedDigest == report.Digest
+}
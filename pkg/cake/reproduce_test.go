@@ -0,0 +1,139 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckoutWorktree(t *testing.T) {
+	repoDir, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(repoDir)
+
+	projectDir := path.Join(repoDir, "images")
+	err = os.MkdirAll(projectDir, os.ModePerm)
+	if err != nil {
+		t.Errorf("Failed to create directory: %v", err)
+	}
+
+	git := func(args ...string) {
+		command := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		command.Dir = repoDir
+		if out, err := command.CombinedOutput(); err != nil {
+			t.Skipf("git is not available: %v %s", err, out)
+		}
+	}
+	git("init", "-q")
+	err = ioutil.WriteFile(path.Join(projectDir, "cake.yaml"), []byte("version: 1\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	revision, err := gitOutput(repoDir, "rev-parse", "HEAD")
+	if err != nil {
+		t.Errorf("Failed to read git revision: %v", err)
+	}
+
+	err = ioutil.WriteFile(path.Join(projectDir, "cake.yaml"), []byte("version: 2\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+	git("commit", "-q", "-a", "-m", "second")
+
+	worktree, cleanup, err := CheckoutWorktree(projectDir, revision)
+	if err != nil {
+		t.Errorf("Unexpected error while checking out worktree: %v", err)
+	}
+	assertFileContents(t, path.Join(worktree, "cake.yaml"), "version: 1\n")
+
+	cleanup()
+	if _, err := os.Stat(worktree); !os.IsNotExist(err) {
+		t.Errorf("Expected worktree %s to be removed", worktree)
+	}
+
+	_, _, err = CheckoutWorktree(projectDir, strings.Repeat("0", 40))
+	if err == nil {
+		t.Errorf("Expected an error for an unknown revision")
+	}
+
+	// revisions are read from image labels and must not be passed to git as options or ref names
+	for _, revision := range []string{"--orphan=x", "HEAD", "master", revision[:12], strings.ToUpper(revision)} {
+		_, _, err = CheckoutWorktree(projectDir, revision)
+		if err == nil {
+			t.Errorf("Expected an error for an invalid revision %s", revision)
+		}
+	}
+
+	_, _, err = CheckoutWorktree(projectDir, revision+DirtyRevisionSuffix)
+	if err == nil {
+		t.Errorf("Expected an error for a revision with uncommitted changes")
+	}
+}
+
+func TestReproductionOverrides(t *testing.T) {
+	chain := []ImageLineage{
+		{Reference: "repo/app:1.0", ImageId: "app", Properties: map[string]interface{}{
+			"parent":   "repo/base:1.0",
+			"version":  "2.0",
+			"packages": []interface{}{"curl"},
+		}},
+		{Reference: "repo/base:1.0", ImageId: "base", Properties: map[string]interface{}{"version": "1.0"}},
+	}
+
+	expected := []PropertyOverride{
+		{Image: "app", Key: "packages", Value: []interface{}{"curl"}, Source: "labels of repo/app:1.0"},
+		{Image: "app", Key: "version", Value: "2.0", Source: "labels of repo/app:1.0"},
+		{Image: "base", Key: "version", Value: "1.0", Source: "labels of repo/base:1.0"},
+	}
+	assert.Equal(t, expected, ReproductionOverrides(chain))
+}
+
+func TestRestoreParentDigests(t *testing.T) {
+	base := &Image{ImageConfig: ImageConfig{Id: "base"}}
+	app := &Image{ImageConfig: ImageConfig{Id: "app"}, Parent: base}
+	images := map[string]*Image{"base": base, "app": app}
+
+	chain := []ImageLineage{
+		{Reference: "repo/app:1.0", ImageId: "app", ParentDigest: "sha256:123",
+			Properties: map[string]interface{}{"parent": "repo/base:1.0"}},
+		{Reference: "repo/base:1.0", ImageId: "base"},
+	}
+	assert.False(t, RestoreParentDigests(chain, images))
+	assert.Equal(t, "sha256:123", base.Digest)
+	assert.Empty(t, app.Digest)
+
+	chain[0].Properties["parent"] = "repo/base:1.0@sha256:123"
+	assert.True(t, RestoreParentDigests(chain, images))
+}
+
+func TestRestoreBaseDigests(t *testing.T) {
+	base := &Image{ImageConfig: ImageConfig{Id: "base"}}
+	app := &Image{ImageConfig: ImageConfig{Id: "app"}, Parent: base}
+	images := map[string]*Image{"base": base, "app": app}
+
+	chain := []ImageLineage{
+		{Reference: "repo/app:1.0", ImageId: "app"},
+		{Reference: "repo/base:1.0", ImageId: "base", BaseDigests: map[string]string{"ubuntu:18.04": "sha256:456"}},
+	}
+	RestoreBaseDigests(chain, images)
+	assert.Equal(t, map[string]string{"ubuntu:18.04": "sha256:456"}, base.BaseDigests)
+	assert.Nil(t, app.BaseDigests)
+}
+
+func TestReleaseTagFromReference(t *testing.T) {
+	image := &Image{ImageConfig: ImageConfig{TagPrefix: "spark", TagSuffix: "gpu"}}
+
+	assert.Equal(t, "1.0", ReleaseTagFromReference(image, "repo/app:spark-1.0-gpu", "abc"))
+	assert.Equal(t, "latest", ReleaseTagFromReference(image, "repo/app:spark-abc-gpu", "abc"))
+	assert.Equal(t, "latest", ReleaseTagFromReference(image, "repo/app@sha256:123", "abc"))
+	assert.Equal(t, "1.0", ReleaseTagFromReference(&Image{}, "localhost:5000/repo/app:1.0", "abc"))
+}
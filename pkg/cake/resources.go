@@ -0,0 +1,147 @@
+package cake
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/docker/go-units"
+)
+
+// ResourcesConfig limits resources available to builds. Sizes are specified in human-readable form,
+// e.g. '512m' or '8g', ulimits in the form of '<name>=<soft limit>[:<hard limit>]', e.g. 'nofile=1024:2048'.
+type ResourcesConfig struct {
+	Memory     string
+	MemorySwap string `yaml:"memswap"`
+	CpuShares  int64  `yaml:"cpu_shares"`
+	CpuQuota   int64  `yaml:"cpu_quota"`
+	ShmSize    string `yaml:"shm_size"`
+	Ulimits    []string
+}
+
+// BuildResources are the resource limits of an image build parsed from the global and image resources config
+type BuildResources struct {
+	Memory     int64
+	MemorySwap int64
+	CpuShares  int64
+	CpuQuota   int64
+	ShmSize    int64
+	Ulimits    []*units.Ulimit
+}
+
+// ResolveResources merges the resources of the image with the global ones, settings of the image take precedence
+// over the global settings. Ulimits are merged by name.
+func ResolveResources(image *Image, config BuildConfig) (BuildResources, error) {
+	var resources BuildResources
+	for _, source := range []ResourcesConfig{config.Resources, image.ImageConfig.Resources} {
+		if err := resources.merge(source); err != nil {
+			return BuildResources{}, fmt.Errorf("invalid resources of image %s: %v", image.ImageConfig.Id, err)
+		}
+	}
+	return resources, nil
+}
+
+func (resources *BuildResources) merge(source ResourcesConfig) error {
+	var err error
+	if len(source.Memory) > 0 {
+		if resources.Memory, err = units.RAMInBytes(source.Memory); err != nil {
+			return err
+		}
+	}
+	if len(source.MemorySwap) > 0 {
+		// -1 allows unlimited swap
+		if source.MemorySwap == "-1" {
+			resources.MemorySwap = -1
+		} else if resources.MemorySwap, err = units.RAMInBytes(source.MemorySwap); err != nil {
+			return err
+		}
+	}
+	if source.CpuShares != 0 {
+		resources.CpuShares = source.CpuShares
+	}
+	if source.CpuQuota != 0 {
+		resources.CpuQuota = source.CpuQuota
+	}
+	if len(source.ShmSize) > 0 {
+		if resources.ShmSize, err = units.RAMInBytes(source.ShmSize); err != nil {
+			return err
+		}
+	}
+	for _, value := range source.Ulimits {
+		ulimit, err := units.ParseUlimit(value)
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i, existing := range resources.Ulimits {
+			if existing.Name == ulimit.Name {
+				resources.Ulimits[i] = ulimit
+				replaced = true
+			}
+		}
+		if !replaced {
+			resources.Ulimits = append(resources.Ulimits, ulimit)
+		}
+	}
+	return nil
+}
+
+// WalkBuildGraphWithResources applies the function to images in parallel like WalkBuildGraphParallel while keeping
+// the total memory declared by images processed at the same time within the memory budget of the config.
+// Resources of all images are validated before the traversal starts.
+func WalkBuildGraphWithResources(graph *Image, config BuildConfig, apply func(image *Image)) error {
+	var budget int64
+	if len(config.MemoryBudget) > 0 {
+		var err error
+		if budget, err = units.RAMInBytes(config.MemoryBudget); err != nil {
+			return fmt.Errorf("invalid memory budget '%s': %v", config.MemoryBudget, err)
+		}
+	}
+
+	memory := make(map[string]int64)
+	var err error
+	WalkBuildGraph(graph, func(image *Image) {
+		if err != nil {
+			return
+		}
+		var resources BuildResources
+		resources, err = ResolveResources(image, config)
+		memory[image.ImageConfig.Id] = resources.Memory
+	})
+	if err != nil {
+		return err
+	}
+
+	walkBuildGraphWithMemoryBudget(graph, budget, func(image *Image) int64 {
+		return memory[image.ImageConfig.Id]
+	}, apply)
+	return nil
+}
+
+// memoryScheduler limits the total memory declared by builds running at the same time
+type memoryScheduler struct {
+	budget int64
+	used   int64
+	cond   *sync.Cond
+}
+
+func newMemoryScheduler(budget int64) *memoryScheduler {
+	return &memoryScheduler{budget: budget, cond: sync.NewCond(&sync.Mutex{})}
+}
+
+// acquire waits until the memory fits into the budget. Memory exceeding the whole budget is acquired
+// when no other memory is in use, so the build runs alone instead of waiting forever.
+func (scheduler *memoryScheduler) acquire(memory int64) {
+	scheduler.cond.L.Lock()
+	defer scheduler.cond.L.Unlock()
+	for scheduler.used > 0 && scheduler.used+memory > scheduler.budget {
+		scheduler.cond.Wait()
+	}
+	scheduler.used += memory
+}
+
+func (scheduler *memoryScheduler) release(memory int64) {
+	scheduler.cond.L.Lock()
+	defer scheduler.cond.L.Unlock()
+	scheduler.used -= memory
+	scheduler.cond.Broadcast()
+}
@@ -0,0 +1,109 @@
+package cake
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveResources(t *testing.T) {
+	config := BuildConfig{
+		Resources: ResourcesConfig{
+			Memory:    "4g",
+			CpuShares: 512,
+			Ulimits:   []string{"nofile=1024:2048", "nproc=512"},
+		},
+	}
+	image := &Image{ImageConfig: ImageConfig{
+		Id: "cuda",
+		Resources: ResourcesConfig{
+			Memory:     "16g",
+			MemorySwap: "-1",
+			ShmSize:    "1g",
+			Ulimits:    []string{"nofile=4096"},
+		},
+	}}
+
+	resources, err := ResolveResources(image, config)
+	if err != nil {
+		t.Errorf("Unexpected error while resolving resources: %v", err)
+	}
+
+	assert.Equal(t, int64(16*1024*1024*1024), resources.Memory)
+	assert.Equal(t, int64(-1), resources.MemorySwap)
+	assert.Equal(t, int64(512), resources.CpuShares)
+	assert.Equal(t, int64(1024*1024*1024), resources.ShmSize)
+	assert.Equal(t, 2, len(resources.Ulimits))
+	assert.Equal(t, "nofile", resources.Ulimits[0].Name)
+	assert.Equal(t, int64(4096), resources.Ulimits[0].Soft)
+	assert.Equal(t, "nproc", resources.Ulimits[1].Name)
+
+	image.ImageConfig.Resources.Memory = "lots"
+	_, err = ResolveResources(image, config)
+	if err == nil {
+		t.Errorf("Expected an error for an invalid memory size")
+	}
+}
+
+func TestWalkBuildGraphWithResources(t *testing.T) {
+	sourceImages := map[string]*Image{
+		"root":    {ImageConfig: ImageConfig{Id: "root"}},
+		"cuda-0":  {ImageConfig: ImageConfig{Id: "cuda-0", Parent: "root", Resources: ResourcesConfig{Memory: "6g"}}},
+		"cuda-1":  {ImageConfig: ImageConfig{Id: "cuda-1", Parent: "root", Resources: ResourcesConfig{Memory: "6g"}}},
+		"cuda-2":  {ImageConfig: ImageConfig{Id: "cuda-2", Parent: "root", Resources: ResourcesConfig{Memory: "6g"}}},
+		"huge":    {ImageConfig: ImageConfig{Id: "huge", Parent: "root", Resources: ResourcesConfig{Memory: "20g"}}},
+		"small-0": {ImageConfig: ImageConfig{Id: "small-0", Parent: "root", Resources: ResourcesConfig{Memory: "1g"}}},
+	}
+
+	root, err := CreateImageBuildGraph(sourceImages)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	config := BuildConfig{MemoryBudget: "16g"}
+	var lock sync.Mutex
+	var used, maxUsed int64
+	visited := 0
+	var hugeRanWith int64
+
+	err = WalkBuildGraphWithResources(root, config, func(image *Image) {
+		resources, _ := ResolveResources(image, config)
+		lock.Lock()
+		used += resources.Memory
+		if image.ImageConfig.Id == "huge" {
+			hugeRanWith = used
+		} else if used > maxUsed {
+			maxUsed = used
+		}
+		visited++
+		lock.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		used -= resources.Memory
+		lock.Unlock()
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if visited != len(sourceImages) {
+		t.Errorf("Expected all %d images to be visited but found %d", len(sourceImages), visited)
+	}
+	if maxUsed > 16*1024*1024*1024 {
+		t.Errorf("Memory declared by images processed in parallel exceeds the budget: %d", maxUsed)
+	}
+	//an image exceeding the whole budget must run alone
+	if hugeRanWith != 20*1024*1024*1024 {
+		t.Errorf("Expected the image exceeding the budget to run alone but the total memory was %d", hugeRanWith)
+	}
+
+	config.MemoryBudget = "plenty"
+	err = WalkBuildGraphWithResources(root, config, func(image *Image) {})
+	if err == nil {
+		t.Errorf("Expected an error for an invalid memory budget")
+	}
+}
@@ -0,0 +1,349 @@
+package cake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/cbroglie/mustache"
+)
+
+// Template engines supported for rendering Dockerfile templates
+const (
+	MustacheEngine   = "mustache"
+	GoTemplateEngine = "gotemplate"
+)
+
+func init() {
+	mustache.AllowMissingVariables = false
+}
+
+// templateContext holds the state of rendering templates for a single image
+type templateContext struct {
+	image  *Image
+	config BuildConfig
+	engine string
+	values map[string]interface{}
+	// files read by templates during rendering which must be included in the image checksum
+	files []string
+}
+
+func newTemplateContext(image *Image, config BuildConfig) *templateContext {
+	// the namespace of referenced images is added to template values but not to the image properties
+	// to keep it out of the image checksum
+	values := image.Properties.Values()
+	values["images"] = imagesNamespace(image, config)
+
+	return &templateContext{
+		image:  image,
+		config: config,
+		engine: templateEngine(image, config),
+		values: values,
+	}
+}
+
+// templateEngine returns the engine configured for the image, the global one or the default (mustache)
+func templateEngine(image *Image, config BuildConfig) string {
+	if len(image.ImageConfig.Engine) > 0 {
+		return image.ImageConfig.Engine
+	}
+	if len(config.Engine) > 0 {
+		return config.Engine
+	}
+	return MustacheEngine
+}
+
+// addFile registers a file as an input of the image checksum
+func (context *templateContext) addFile(path string) {
+	path = filepath.Clean(path)
+	if !contains(context.files, path) {
+		context.files = append(context.files, path)
+	}
+}
+
+func (context *templateContext) render(templateFile string) (string, error) {
+	content, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		return "", err
+	}
+	return context.renderContent(templateFile, string(content))
+}
+
+// renderContent renders the contents of a template file. The file location is used for looking up partials.
+func (context *templateContext) renderContent(templateFile string, content string) (string, error) {
+	switch context.engine {
+	case MustacheEngine:
+		return context.renderMustache(templateFile, content)
+	case GoTemplateEngine:
+		return context.renderGoTemplate(templateFile, content)
+	default:
+		return "", fmt.Errorf("unknown template engine '%s' for image %s, supported engines are: %s, %s",
+			context.engine, context.image.ImageConfig.Id, MustacheEngine, GoTemplateEngine)
+	}
+}
+
+func (context *templateContext) renderMustache(templateFile string, content string) (string, error) {
+	provider := &partialProvider{context: context, directory: context.partialsDir(templateFile)}
+	tmpl, err := mustache.ParseStringPartials(content, provider)
+	if err != nil {
+		return "", err
+	}
+	return tmpl.Render(context.values)
+}
+
+// partialsDir returns the directory partials are looked up in. Unless partials_dir is configured, partials
+// are looked up in the directory of the template.
+func (context *templateContext) partialsDir(templateFile string) string {
+	if len(context.config.PartialsDir) > 0 {
+		return context.config.PartialsDir
+	}
+	return filepath.Dir(templateFile)
+}
+
+// partialProvider resolves mustache partials by name and registers used partials as checksum inputs
+type partialProvider struct {
+	context   *templateContext
+	directory string
+}
+
+func (provider *partialProvider) Get(name string) (string, error) {
+	file, err := findPartial(provider.directory, name, ".mustache")
+	if err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	provider.context.addFile(file)
+	return string(content), nil
+}
+
+func (context *templateContext) renderGoTemplate(templateFile string, content string) (string, error) {
+	tmpl, partials, err := parseGoTemplateContent(templateFile, content, context.partialsDir(templateFile), context.funcs())
+	if err != nil {
+		return "", err
+	}
+	for _, file := range partials {
+		context.addFile(file)
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, context.values)
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// parseGoTemplate parses a Go template along with all the partials it includes and returns the files of
+// included partials. Templates included with {{ template "<name>" . }} and not defined in the template itself
+// are loaded from the partials directory until all the (transitively) included templates are defined.
+func parseGoTemplate(templateFile string, partialsDir string, funcs template.FuncMap) (*template.Template, []string, error) {
+	content, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseGoTemplateContent(templateFile, string(content), partialsDir, funcs)
+}
+
+func parseGoTemplateContent(templateFile string, content string, partialsDir string, funcs template.FuncMap) (*template.Template, []string, error) {
+	tmpl, err := template.New(filepath.Base(templateFile)).
+		Option("missingkey=error").
+		Funcs(funcs).
+		Parse(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var partials []string
+	for {
+		var undefined []string
+		for _, name := range usedTemplates(tmpl, tmpl.Name()) {
+			if tmpl.Lookup(name) == nil {
+				undefined = append(undefined, name)
+			}
+		}
+		if len(undefined) == 0 {
+			break
+		}
+
+		for _, name := range undefined {
+			file, err := findPartial(partialsDir, name, ".tmpl")
+			if err != nil {
+				return nil, nil, err
+			}
+			partialContent, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			_, err = tmpl.New(name).Parse(string(partialContent))
+			if err != nil {
+				return nil, nil, err
+			}
+			partials = append(partials, file)
+		}
+	}
+	return tmpl, partials, nil
+}
+
+// funcs returns helper functions available in Go templates
+func (context *templateContext) funcs() template.FuncMap {
+	return template.FuncMap{
+		"default":     defaultValue,
+		"split":       split,
+		"join":        join,
+		"replace":     replace,
+		"lower":       strings.ToLower,
+		"upper":       strings.ToUpper,
+		"trim":        strings.TrimSpace,
+		"semverMajor": semverComponent(0),
+		"semverMinor": semverComponent(1),
+		"semverPatch": semverComponent(2),
+		"env":         os.Getenv,
+		"file":        context.file,
+		"image":       context.imageReference,
+	}
+}
+
+// file returns the contents of a file and registers it as an input of the image checksum
+func (context *templateContext) file(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read file referenced in template: %v", err)
+	}
+	context.addFile(path)
+	return string(content), nil
+}
+
+// imageReference returns the full name with the stable tag of an ancestor or a dependency of the image
+func (context *templateContext) imageReference(id string) (string, error) {
+	namespace, _ := context.values["images"].(map[string]interface{})
+	reference, found := namespace[id].(map[string]interface{})
+	if !found {
+		return "", fmt.Errorf("image %s is not an ancestor or a dependency of image %s", id, context.image.ImageConfig.Id)
+	}
+	return reference["stable_tag"].(string), nil
+}
+
+// imagesNamespace returns references of the image ancestors and dependencies by image ID. Only these images are
+// guaranteed to be processed (and pushed, if built) before the image itself, so referencing any other image
+// from a template is an error.
+func imagesNamespace(image *Image, config BuildConfig) map[string]interface{} {
+	namespace := make(map[string]interface{})
+	referenced := append([]*Image{}, image.Dependencies...)
+	for ancestor := image.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		referenced = append(referenced, ancestor)
+	}
+
+	for _, reference := range referenced {
+		namespace[reference.ImageConfig.Id] = map[string]interface{}{
+			"full_name":    reference.getFullName(),
+			"stable_tag":   fmt.Sprintf("%s:%s", reference.getFullName(), reference.getStableTag(config)),
+			"checksum_tag": fmt.Sprintf("%s:%s", reference.getFullName(), reference.getChecksumTag(config)),
+			"digest":       reference.Digest,
+		}
+	}
+	return namespace
+}
+
+// findPartial returns the file of a partial with the provided name from the directory. A file with the engine-specific
+// extension (e.g. 'apt_install.mustache') takes precedence over a file named exactly as the partial.
+func findPartial(directory string, name string, extension string) (string, error) {
+	for _, file := range []string{filepath.Join(directory, name+extension), filepath.Join(directory, name)} {
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find partial '%s' in %s", name, directory)
+}
+
+// usedTemplates returns names of all templates included (directly or transitively) from the named template
+func usedTemplates(tmpl *template.Template, name string) []string {
+	var used []string
+	var visit func(node parse.Node)
+	visit = func(node parse.Node) {
+		included, isTemplate := node.(*parse.TemplateNode)
+		if !isTemplate || contains(used, included.Name) {
+			return
+		}
+		used = append(used, included.Name)
+		if definition := tmpl.Lookup(included.Name); definition != nil && definition.Tree != nil {
+			walkGoTemplate(definition.Tree.Root, visit)
+		}
+	}
+
+	if root := tmpl.Lookup(name); root != nil && root.Tree != nil {
+		walkGoTemplate(root.Tree.Root, visit)
+	}
+	return used
+}
+
+// defaultValue returns the value if it's not empty and the default otherwise. Used in pipelines
+// e.g. {{ index . "version" | default "1.0" }}
+func defaultValue(defaultValue interface{}, value interface{}) interface{} {
+	if value == nil {
+		return defaultValue
+	}
+	if str, isString := value.(string); isString && len(str) == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func split(separator string, value string) []string {
+	return strings.Split(value, separator)
+}
+
+func join(separator string, values interface{}) (string, error) {
+	switch typed := values.(type) {
+	case []string:
+		return strings.Join(typed, separator), nil
+	case []interface{}:
+		items := make([]string, len(typed))
+		for i, item := range typed {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, separator), nil
+	default:
+		return "", fmt.Errorf("unable to join values of type %T", values)
+	}
+}
+
+func replace(old string, new string, value string) string {
+	return strings.Replace(value, old, new, -1)
+}
+
+// semverComponent returns a function extracting the major, minor or patch component of a version.
+// A leading 'v' as well as pre-release and build metadata are ignored, missing components are treated as '0'.
+func semverComponent(index int) func(version string) (string, error) {
+	return func(version string) (string, error) {
+		components, err := parseVersion(version)
+		if err != nil {
+			return "", err
+		}
+		if index >= len(components) {
+			return "0", nil
+		}
+		return components[index], nil
+	}
+}
+
+func parseVersion(version string) ([]string, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+
+	components := strings.Split(trimmed, ".")
+	for _, component := range components {
+		if len(component) == 0 || strings.Trim(component, "0123456789") != "" {
+			return nil, fmt.Errorf("'%s' is not a valid version", version)
+		}
+	}
+	return components, nil
+}
@@ -0,0 +1,385 @@
+package cake
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderGoTemplate(t *testing.T) {
+	os.Setenv("CAKE_TEST_BUILD_NUMBER", "42")
+	defer os.Unsetenv("CAKE_TEST_BUILD_NUMBER")
+
+	template := `FROM {{.parent}}
+{{- if eq (semverMajor .spark_version) "3" }}
+ENV SCALA_VERSION 2.12
+{{- else }}
+ENV SCALA_VERSION 2.11
+{{- end }}
+ENV SPARK_MINOR {{ semverMajor .spark_version }}.{{ semverMinor .spark_version }}
+ENV HADOOP_VERSION {{ index . "hadoop_version" | default "2.7" }}
+ENV BUILD {{ env "CAKE_TEST_BUILD_NUMBER" }}
+ENV COMPONENTS {{ .components | split "," | join " " }}
+{{- range .packages }}
+RUN pip install {{ . }}
+{{- end }}
+`
+	expectedDockerfile := `FROM foo/bar:baz
+ENV SCALA_VERSION 2.12
+ENV SPARK_MINOR 3.0
+ENV HADOOP_VERSION 2.7
+ENV BUILD 42
+ENV COMPONENTS core sql
+RUN pip install numpy
+RUN pip install pandas
+`
+
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte(template), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := Image{
+		Parent: &Image{
+			ImageConfig: ImageConfig{
+				Repository: "foo",
+				Name:       "bar",
+			},
+			Checksum: "baz",
+		},
+		ImageConfig: ImageConfig{
+			Id:       "child",
+			Template: templateFile,
+			Engine:   GoTemplateEngine,
+			Properties: Properties{
+				"spark_version": "3.0.1",
+				"components":    "core,sql",
+				"packages":      []interface{}{"numpy", "pandas"},
+			},
+		},
+	}
+
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	bytes, err := ioutil.ReadFile(image.Dockerfile)
+	if err != nil {
+		t.Errorf("Failed to read bytes from file %s: %v", image.Dockerfile, err)
+	}
+	contents := string(bytes)
+
+	if strings.Compare(expectedDockerfile, contents) != 0 {
+		t.Errorf("Rendered Dockerfile contents differ from the expected.\nExpected:\n%s\nRendered:\n%s", expectedDockerfile, contents)
+	}
+}
+
+func TestGoTemplateEngineFromGlobalConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte(`FROM ubuntu:{{ .version }}`), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := Image{
+		ImageConfig: ImageConfig{
+			Id:       "image",
+			Template: templateFile,
+		},
+	}
+
+	config := BuildConfig{
+		BaseDir:          tmpDir,
+		Engine:           GoTemplateEngine,
+		GlobalProperties: Properties{"version": "18.04"},
+	}
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	bytes, err := ioutil.ReadFile(image.Dockerfile)
+	if err != nil {
+		t.Errorf("Failed to read bytes from file %s: %v", image.Dockerfile, err)
+	}
+	if string(bytes) != "FROM ubuntu:18.04" {
+		t.Errorf("Unexpected rendered Dockerfile contents: %s", bytes)
+	}
+
+	// the image-level engine takes precedence over the global one
+	image.ImageConfig.Engine = MustacheEngine
+	err = image.RenderDockerfileFromTemplate(config)
+	if err == nil {
+		t.Errorf("Expected error while rendering Go template with mustache engine")
+	}
+
+	image.ImageConfig.Engine = "unknown"
+	err = image.RenderDockerfileFromTemplate(config)
+	if err == nil {
+		t.Errorf("Expected error while rendering template with unknown engine")
+	}
+}
+
+func TestGoTemplateMissingProperty(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte(`FROM ubuntu:{{ .version }}`), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := Image{
+		ImageConfig: ImageConfig{
+			Template: templateFile,
+			Engine:   GoTemplateEngine,
+		},
+	}
+
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
+	if err == nil {
+		t.Errorf("Expected error while rendering Dockerfile from template with missing variables")
+	}
+}
+
+func TestGoTemplateFileAndImageHelpers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte(`FROM {{ image "root" }}
+RUN echo '{{ file "testdata/basic/shared/script.sh" | trim }}' > /script.sh
+`), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	script, err := ioutil.ReadFile("testdata/basic/shared/script.sh")
+	if err != nil {
+		t.Errorf("Unable to read file: %v", err)
+	}
+
+	root := &Image{
+		ImageConfig: ImageConfig{Id: "root", Repository: "foo", Name: "root"},
+		Checksum:    "12345",
+	}
+	image := &Image{
+		Parent: root,
+		ImageConfig: ImageConfig{
+			Id:       "child",
+			Template: templateFile,
+			Engine:   GoTemplateEngine,
+		},
+	}
+	root.Children = []*Image{image}
+
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir, ReleaseTag: "1.0"})
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	bytes, err := ioutil.ReadFile(image.Dockerfile)
+	if err != nil {
+		t.Errorf("Failed to read bytes from file %s: %v", image.Dockerfile, err)
+	}
+
+	expectedDockerfile := "FROM foo/root:1.0\nRUN echo '" + strings.TrimSpace(string(script)) + "' > /script.sh\n"
+	if string(bytes) != expectedDockerfile {
+		t.Errorf("Rendered Dockerfile contents differ from the expected.\nExpected:\n%s\nRendered:\n%s", expectedDockerfile, bytes)
+	}
+
+	expectedInputs := []string{"testdata/basic/shared/script.sh"}
+	if !reflect.DeepEqual(expectedInputs, image.InputFiles) {
+		t.Errorf("Template input files differ from the expected.\nExpected:\n%s\nFound:\n%s", expectedInputs, image.InputFiles)
+	}
+}
+
+func TestImagesNamespace(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte(`FROM {{images.builder.stable_tag}} AS builder
+FROM {{images.root.full_name}}@{{images.root.digest}}
+LABEL builder={{images.builder.checksum_tag}}
+`), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	root := &Image{
+		ImageConfig: ImageConfig{Id: "root", Repository: "foo", Name: "root"},
+		Checksum:    "12345",
+		Digest:      "sha256:abcdef",
+	}
+	builder := &Image{
+		Parent:      root,
+		ImageConfig: ImageConfig{Id: "builder", Repository: "foo", Name: "builder"},
+		Checksum:    "67890",
+	}
+	image := &Image{
+		Parent:       root,
+		Dependencies: []*Image{builder},
+		ImageConfig:  ImageConfig{Id: "child", Template: templateFile},
+	}
+	root.Children = []*Image{builder, image}
+
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir, ReleaseTag: "1.0"})
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	expectedDockerfile := `FROM foo/builder:1.0 AS builder
+FROM foo/root@sha256:abcdef
+LABEL builder=foo/builder:67890
+`
+	assertFileContents(t, image.Dockerfile, expectedDockerfile)
+
+	//sibling images which are not declared as dependencies are not visible in templates
+	image.Dependencies = nil
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir, ReleaseTag: "1.0"})
+	if err == nil {
+		t.Errorf("Expected rendering to fail for an image which is not a dependency")
+	}
+}
+
+func TestSemverComponents(t *testing.T) {
+	cases := []struct {
+		version string
+		major   string
+		minor   string
+		patch   string
+	}{
+		{"2.4.5", "2", "4", "5"},
+		{"v1.15", "1", "15", "0"},
+		{"10", "10", "0", "0"},
+		{"3.0.0-preview2", "3", "0", "0"},
+		{"1.2.3+build.7", "1", "2", "3"},
+	}
+
+	for _, c := range cases {
+		major, _ := semverComponent(0)(c.version)
+		minor, _ := semverComponent(1)(c.version)
+		patch, _ := semverComponent(2)(c.version)
+		if major != c.major || minor != c.minor || patch != c.patch {
+			t.Errorf("Unexpected components of version %s: %s, %s, %s", c.version, major, minor, patch)
+		}
+	}
+
+	for _, invalid := range []string{"", "latest", "1..2", "1.x"} {
+		_, err := semverComponent(0)(invalid)
+		if err == nil {
+			t.Errorf("Expected error while parsing invalid version '%s'", invalid)
+		}
+	}
+}
+
+func TestRenderPartials(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	partialsDir := path.Join(tmpDir, "partials")
+	err = os.Mkdir(partialsDir, os.ModePerm)
+	if err != nil {
+		t.Errorf("Failed to create directory %s: %v", partialsDir, err)
+	}
+
+	files := map[string]string{
+		path.Join(partialsDir, "apt_install.mustache"): "RUN apt-get update && apt-get install -y {{packages}} && {{> apt_cleanup}}\n",
+		path.Join(partialsDir, "apt_cleanup"):          "rm -rf /var/lib/apt/lists/*",
+		path.Join(partialsDir, "user.mustache"):        "USER {{user}}\n",
+		path.Join(partialsDir, "apt_install.tmpl"):     "RUN apt-get install -y {{ .packages }} && {{ template \"apt_cleanup\" }}\n",
+		path.Join(partialsDir, "user.tmpl"):            "USER {{ .user }}\n",
+		path.Join(tmpDir, "mustache.template"):         "FROM ubuntu\n{{> apt_install}}\n",
+		path.Join(tmpDir, "go.template"):               "FROM ubuntu\n{{ template \"apt_install\" . }}",
+	}
+	for file, content := range files {
+		err = ioutil.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Errorf("Failed to write file: %v", err)
+		}
+	}
+
+	config := BuildConfig{
+		BaseDir:          tmpDir,
+		PartialsDir:      partialsDir,
+		GlobalProperties: Properties{"packages": "curl", "user": "nobody"},
+	}
+
+	mustacheImage := Image{ImageConfig: ImageConfig{
+		Id:       "mustache",
+		Template: path.Join(tmpDir, "mustache.template"),
+	}}
+	err = mustacheImage.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	assertFileContents(t, mustacheImage.Dockerfile, "FROM ubuntu\nRUN apt-get update && apt-get install -y curl && rm -rf /var/lib/apt/lists/*\n")
+
+	expectedInputs := []string{path.Join(partialsDir, "apt_install.mustache"), path.Join(partialsDir, "apt_cleanup")}
+	if !reflect.DeepEqual(expectedInputs, mustacheImage.InputFiles) {
+		t.Errorf("Template input files differ from the expected.\nExpected:\n%s\nFound:\n%s", expectedInputs, mustacheImage.InputFiles)
+	}
+
+	goImage := Image{ImageConfig: ImageConfig{
+		Id:       "go",
+		Template: path.Join(tmpDir, "go.template"),
+		Engine:   GoTemplateEngine,
+	}}
+	err = goImage.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	assertFileContents(t, goImage.Dockerfile, "FROM ubuntu\nRUN apt-get install -y curl && rm -rf /var/lib/apt/lists/*\n")
+
+	expectedInputs = []string{path.Join(partialsDir, "apt_install.tmpl"), path.Join(partialsDir, "apt_cleanup")}
+	if !reflect.DeepEqual(expectedInputs, goImage.InputFiles) {
+		t.Errorf("Template input files differ from the expected.\nExpected:\n%s\nFound:\n%s", expectedInputs, goImage.InputFiles)
+	}
+}
+
+func TestMissingPartial(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	templateFile := path.Join(tmpDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte("FROM ubuntu\n{{> missing}}\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := Image{ImageConfig: ImageConfig{Template: templateFile}}
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir, PartialsDir: tmpDir})
+	if err == nil {
+		t.Errorf("Expected error while rendering template with missing partial")
+	}
+}
+
+func assertFileContents(t *testing.T, file string, expected string) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Errorf("Failed to read bytes from file %s: %v", file, err)
+	}
+	if string(bytes) != expected {
+		t.Errorf("File %s contents differ from the expected.\nExpected:\n%s\nFound:\n%s", file, expected, bytes)
+	}
+}
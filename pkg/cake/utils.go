@@ -3,6 +3,7 @@ package cake
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,75 +14,217 @@ import (
 	"strings"
 
 	"github.com/facebookgo/symwalk"
-
-	"github.com/cbroglie/mustache"
 )
 
+// GeneratedDockerFileNamePrefix is the name prefix of generated Dockerfiles committed next to templates
 const GeneratedDockerFileNamePrefix = "Dockerfile.generated"
 const DefaultShaLength = 64
 
-func (image *Image) RenderDockerfileFromTemplate(config BuildConfig) error {
-	directory := filepath.Dir(image.ImageConfig.Template)
+// DefaultRenderDir is the directory relative to the project root which Dockerfiles and templated files
+// are rendered to (in a subdirectory per image) unless a different location is configured
+const DefaultRenderDir = ".cake/generated"
+
+// GeneratedDockerfileName is the name of the Dockerfile rendered from the image template
+const GeneratedDockerfileName = "Dockerfile"
+
+// DefaultProvenanceDir is the directory provenance statements are written to by default
+const DefaultProvenanceDir = ".cake/provenance"
 
-	templateProperties := config.GlobalProperties
+// ParentImageBuildArg is the build argument the parent image is passed in to plain Dockerfiles
+const ParentImageBuildArg = "PARENT_IMAGE"
 
-	if templateProperties == nil {
-		templateProperties = make(map[string]string)
+// RenderDockerfileFromTemplate renders the image template along with templated files. Images built from plain
+// Dockerfiles are not rendered, the parent image and properties are passed to them as build arguments instead.
+func (image *Image) RenderDockerfileFromTemplate(config BuildConfig) error {
+	if len(image.ImageConfig.Template) > 0 && image.isPlainDockerfile() {
+		return fmt.Errorf("image %s must define either a template or a dockerfile but not both", image.ImageConfig.Id)
 	}
 
-	if len(image.ImageConfig.Properties) == 0 && len(templateProperties) == 0 {
+	image.Properties = ResolveProperties(image, config)
+	context := newTemplateContext(image, config)
+
+	if len(image.Properties.values) == 0 {
 		log.Printf("No properties provided for templating")
+	}
+
+	// every image is rendered to its own directory so that images sharing a template don't overwrite each other
+	renderDir, err := config.renderDirectory()
+	if err != nil {
+		return err
+	}
+	// the directory is removed below, so the image ID must not point outside of the render directory
+	id := image.ImageConfig.Id
+	if len(id) == 0 || id == "." || id == ".." || filepath.Base(id) != id {
+		return fmt.Errorf("image ID '%s' can't be used as a directory name", id)
+	}
+	directory := filepath.Join(renderDir, id)
+	if !isInDirectory(directory, renderDir) {
+		return fmt.Errorf("render directory of image %s is located outside of %s", id, renderDir)
+	}
+	generatedDir, err := config.contextPath(directory)
+	if err != nil {
+		return fmt.Errorf("invalid render directory for image %s: %v", image.ImageConfig.Id, err)
+	}
+	context.values["generated_dir"] = generatedDir
+
+	image.RenderedFiles = nil
+	if config.RenderInMemory {
+		image.RenderedFiles = make(map[string][]byte)
 	} else {
-		for key, value := range image.ImageConfig.Properties {
-			templateProperties[key] = value
+		// removing files rendered previously to avoid leftovers of files removed from the config
+		err = os.RemoveAll(directory)
+		if err != nil {
+			return fmt.Errorf("failed to clean up directory %s: %v", directory, err)
+		}
+		err = os.MkdirAll(directory, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", directory, err)
 		}
 	}
 
-	if image.Parent != nil {
-		templateProperties["parent"] = fmt.Sprintf("%s:%s", image.Parent.getFullName(), image.Parent.getStableTag(config))
+	image.GeneratedDir = directory
+	image.BuildArgs = make(map[string]string)
+
+	if image.isPlainDockerfile() {
+		image.Dockerfile = image.ImageConfig.Dockerfile
+		image.LineMap = nil
+		image.addPlainDockerfileBuildArgs()
+	} else {
+		rendered, err := context.render(image.ImageConfig.Template)
+		if err != nil {
+			return fmt.Errorf("error while rendering template: %v", err)
+		}
+		image.LineMap = context.lineMap(image.ImageConfig.Template, rendered)
+
+		dockerfile := filepath.Join(directory, GeneratedDockerfileName)
+		err = image.writeRenderedFile(dockerfile, rendered, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed while writing templated file: %v", err)
+		}
+		image.Dockerfile = dockerfile
+	}
+
+	// explicitly configured build arguments take precedence over the ones passed to plain Dockerfiles
+	for name, value := range image.ImageConfig.BuildArgs {
+		image.BuildArgs[name] = value
 	}
 
-	mustache.AllowMissingVariables = false
-	rendered, err := mustache.RenderFile(image.ImageConfig.Template, templateProperties)
+	err = image.renderTemplatedFiles(context)
 	if err != nil {
-		return fmt.Errorf("error while rendering template: %v", err)
+		return err
 	}
+	image.InputFiles = context.files
+
+	return nil
+}
 
-	// including prefix and suffix into the generated file name for better readability
-	dockerfile := fmt.Sprintf("%s/%s", directory, GeneratedDockerFileNamePrefix)
-	if len(image.ImageConfig.TagPrefix) > 0 {
-		dockerfile = fmt.Sprintf("%s.%s", dockerfile, image.ImageConfig.TagPrefix)
+// addPlainDockerfileBuildArgs passes the parent image and the image properties as build arguments.
+// Structured values are passed in JSON format.
+func (image *Image) addPlainDockerfileBuildArgs() {
+	for _, property := range image.Properties.Properties() {
+		if property.Name != "parent" {
+			image.BuildArgs[property.Name] = FormatPropertyValue(property.Value)
+		}
 	}
-	if len(image.ImageConfig.TagSuffix) > 0 {
-		dockerfile = fmt.Sprintf("%s.%s", dockerfile, image.ImageConfig.TagSuffix)
+	if parent, found := image.Properties.values["parent"]; found {
+		image.BuildArgs[ParentImageBuildArg] = FormatPropertyValue(parent)
 	}
+}
 
-	err = ioutil.WriteFile(dockerfile, []byte(rendered), os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("failed while writing templated file: %v", err)
+// buildSettings returns Docker build settings which affect image contents. Settings which only affect how
+// the image is built (e.g. no_cache or network_mode) are not included, neither are empty settings.
+func (image *Image) buildSettings() map[string]interface{} {
+	settings := make(map[string]interface{})
+	if len(image.BuildArgs) > 0 {
+		settings["build_args"] = image.BuildArgs
+	}
+	if len(image.ImageConfig.Target) > 0 {
+		settings["target"] = image.ImageConfig.Target
 	}
+	if len(image.ImageConfig.Labels) > 0 {
+		settings["labels"] = image.ImageConfig.Labels
+	}
+	if len(image.ImageConfig.Platform) > 0 {
+		settings["platform"] = image.ImageConfig.Platform
+	}
+	if len(image.BaseDigests) > 0 {
+		settings["base_digests"] = image.BaseDigests
+	}
+	return settings
+}
 
-	image.Dockerfile = dockerfile
+// renderTemplatedFiles renders templated_files of the image with the image property scope. Every file is written
+// to the generated directory of the image preserving its path relative to the project root and its permissions.
+func (image *Image) renderTemplatedFiles(context *templateContext) error {
+	image.GeneratedFiles = nil
+	for _, file := range image.ImageConfig.TemplatedFiles {
+		if filepath.IsAbs(file) || strings.HasPrefix(filepath.Clean(file), "..") {
+			return fmt.Errorf("templated file %s of image %s must be a relative path within the project directory", file, image.ImageConfig.Id)
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("one of the templated files of image %s doesn't exist: %s", image.ImageConfig.Id, file)
+		}
+
+		rendered, err := context.render(file)
+		if err != nil {
+			return fmt.Errorf("error while rendering templated file %s: %v", file, err)
+		}
+
+		target := filepath.Join(image.GeneratedDir, file)
+		err = image.writeRenderedFile(target, rendered, info.Mode())
+		if err != nil {
+			return fmt.Errorf("failed while writing templated file: %v", err)
+		}
+		image.GeneratedFiles = append(image.GeneratedFiles, target)
+	}
 	return nil
 }
 
+// writeRenderedFile writes a rendered file creating its directory, or keeps its contents in memory if the image
+// is rendered in memory
+func (image *Image) writeRenderedFile(file string, content string, mode os.FileMode) error {
+	if image.RenderedFiles != nil {
+		image.RenderedFiles[file] = []byte(content)
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for rendered file %s: %v", file, err)
+	}
+	return ioutil.WriteFile(file, []byte(content), mode)
+}
+
+// readRenderedFile returns the contents of a rendered file from memory if the image is rendered in memory,
+// other files are read from disk
+func (image *Image) readRenderedFile(file string) ([]byte, error) {
+	if content, found := image.RenderedFiles[file]; found {
+		return content, nil
+	}
+	return ioutil.ReadFile(file)
+}
+
+// CleanRenderDirectory removes all the files rendered for images of the build
+func CleanRenderDirectory(config BuildConfig) error {
+	directory, err := config.renderDirectory()
+	if err != nil {
+		return err
+	}
+	log.Printf("Removing rendered files in %s", directory)
+	return os.RemoveAll(directory)
+}
+
 func (image *Image) CalculateChecksum(checksumLength int) error {
-	directory := filepath.Dir(image.Dockerfile)
-	files, err := listFiles(directory)
+	directory := filepath.Dir(image.sourceFile())
+	listedFiles, err := listFiles(directory)
 	if err != nil {
 		return fmt.Errorf("error while listing files in directory: %s. %v", directory, err)
 	}
 
-	// Filtering out files and folders excluded from checksums in the config.
-	// Also, filtering out generated Dockerfiles not belonging to the current image.
-	// This is required for the cases when a single Dockerfile.template is used for multiple images
-	// with different parameters. Each image defined in cake.yaml using the same Dockerfile.template
-	// will generate Dockerfile.generated[<tag suffix>] used in checksum for that specific image.
-	filteredFiles := make([]string, 0)
-	for _, file := range files {
-		isGeneratedDockerfile := strings.Contains(file, GeneratedDockerFileNamePrefix)
-		isImageFile := file == image.Dockerfile
-
+	// Filtering out files and folders excluded from checksums in the config
+	files := make([]string, 0)
+	for _, file := range listedFiles {
 		excluded := false
 		for _, s := range image.ImageConfig.ExcludedFiles {
 			if strings.HasPrefix(file, s) {
@@ -90,11 +233,10 @@ func (image *Image) CalculateChecksum(checksumLength int) error {
 			}
 		}
 
-		if (!isGeneratedDockerfile || isImageFile) && !excluded {
-			filteredFiles = append(filteredFiles, file)
+		if !excluded {
+			files = append(files, file)
 		}
 	}
-	files = filteredFiles
 
 	for _, file := range image.ImageConfig.ExtraFiles {
 		info, err := os.Stat(file)
@@ -114,6 +256,47 @@ func (image *Image) CalculateChecksum(checksumLength int) error {
 		}
 	}
 
+	// Filtering out files rendered for other images in case the render directory is located in one of the
+	// listed directories. Files rendered for the image itself are added explicitly below.
+	if len(image.GeneratedDir) > 0 {
+		renderDir := filepath.Dir(image.GeneratedDir)
+		filteredFiles := make([]string, 0)
+		for _, file := range files {
+			if !isInDirectory(file, renderDir) {
+				filteredFiles = append(filteredFiles, file)
+			}
+		}
+		files = filteredFiles
+	}
+
+	// Filtering out committed Dockerfiles of all images. They are rendering output, and images sharing
+	// a template directory would otherwise include each other's Dockerfiles into their checksums.
+	committedDockerfiles := image.committedDockerfiles()
+	filteredFiles := make([]string, 0)
+	for _, file := range files {
+		committed := false
+		for _, dockerfile := range committedDockerfiles {
+			if isSameFile(file, dockerfile) {
+				committed = true
+				break
+			}
+		}
+		if !committed {
+			filteredFiles = append(filteredFiles, file)
+		}
+	}
+	files = filteredFiles
+
+	// the rendered Dockerfile, files read by templates during rendering and rendered templated files
+	// are included unless they are already listed
+	inputs := append([]string{image.Dockerfile}, image.InputFiles...)
+	inputs = append(inputs, image.GeneratedFiles...)
+	for _, file := range inputs {
+		if !contains(files, file) {
+			files = append(files, file)
+		}
+	}
+
 	sort.Strings(files)
 	imageDetailsStr := fmt.Sprintf("[%s][%s]", image.ImageConfig.TagPrefix, image.ImageConfig.TagSuffix)
 
@@ -123,17 +306,51 @@ func (image *Image) CalculateChecksum(checksumLength int) error {
 	}
 
 	checksums := ""
+	image.ChecksumInputs = make(map[string]string)
 
 	for _, file := range files {
-		contentChecksum, err := getContentChecksum(file)
+		var contentChecksum string
+		if content, rendered := image.RenderedFiles[file]; rendered {
+			hash := sha256.Sum256(content)
+			contentChecksum = hex.EncodeToString(hash[:])
+		} else {
+			contentChecksum, err = getContentChecksum(file)
+		}
 		if err != nil {
 			return err
 		} else {
 			checksums = checksums + contentChecksum
+			image.ChecksumInputs[file] = contentChecksum
 		}
 
 	}
 
+	// structured property values are serialized canonically (with sorted map keys) and included in addition
+	// to the rendered files, so that any change of a list or a nested value triggers a rebuild
+	if image.Properties != nil {
+		structuredValues, err := image.Properties.structuredValues()
+		if err != nil {
+			return err
+		}
+		if len(structuredValues) > 0 {
+			log.Printf("Structured properties used for content checksum for %s%s: %s", image.ImageConfig.Name, imageDetailsStr, structuredValues)
+			hash := sha256.Sum256([]byte(structuredValues))
+			checksums = checksums + hex.EncodeToString(hash[:])
+		}
+	}
+
+	// build settings are not a part of the Dockerfile, so they are serialized canonically (with sorted keys)
+	// and included in the checksum
+	if settings := image.buildSettings(); len(settings) > 0 {
+		out, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		log.Printf("Build settings used for content checksum for %s%s: %s", image.ImageConfig.Name, imageDetailsStr, out)
+		hash := sha256.Sum256(out)
+		checksums = checksums + hex.EncodeToString(hash[:])
+	}
+
 	hash := sha256.New()
 	hash.Write([]byte(checksums))
 	//converting checksum to string and truncating to the specified checksumLength
@@ -174,3 +391,38 @@ func listFiles(directory string) ([]string, error) {
 
 	return files, nil
 }
+
+// isSameFile checks whether both paths point to the same location after resolving them to absolute paths
+func isSameFile(file string, other string) bool {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	absOther, err := filepath.Abs(other)
+	if err != nil {
+		return false
+	}
+	return absFile == absOther
+}
+
+// isInDirectory returns true if the file is located in the directory or any of its subdirectories
+func isInDirectory(file string, directory string) bool {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(absFile, absDirectory+string(filepath.Separator))
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -49,23 +49,26 @@ RUN echo "%s" > /version.txt
 			Checksum: "baz",
 		},
 		ImageConfig: ImageConfig{
+			Id:        "child",
 			Template:  tmpFile.Name(),
 			TagPrefix: "child",
 			TagSuffix: "alpha",
-			Properties: map[string]string{
+			Properties: Properties{
 				"tmpl_property": expectedEnvVar,
 				"tmpl_version":  expectedVersionVar,
 			},
 		},
 	}
 
-	err = image.RenderDockerfileFromTemplate(BuildConfig{})
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
 	if err != nil {
 		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
 	}
 
-	filename := fmt.Sprintf("%s.%s.%s", GeneratedDockerFileNamePrefix, image.ImageConfig.TagPrefix, image.ImageConfig.TagSuffix)
-	dockerfile := path.Join(tmpDir, filename)
+	dockerfile := path.Join(tmpDir, DefaultRenderDir, image.ImageConfig.Id, GeneratedDockerfileName)
+	if image.Dockerfile != dockerfile {
+		t.Errorf("Expected Dockerfile to be rendered to %s but found %s", dockerfile, image.Dockerfile)
+	}
 
 	_, err = os.Stat(dockerfile)
 	if os.IsNotExist(err) {
@@ -114,7 +117,8 @@ ENV GLOBAL_PROPERTY_OVERRIDE %s
 	}
 
 	buildConfig := BuildConfig{
-		GlobalProperties: map[string]string{
+		BaseDir: tmpDir,
+		GlobalProperties: Properties{
 			"global_tmpl_property":   expectedGlobalProperty,
 			"tmpl_property_override": "DEFAULT",
 		},
@@ -129,8 +133,9 @@ ENV GLOBAL_PROPERTY_OVERRIDE %s
 			Checksum: "baz",
 		},
 		ImageConfig: ImageConfig{
+			Id:       "child",
 			Template: tmpFile.Name(),
-			Properties: map[string]string{
+			Properties: Properties{
 				"local_tmpl_property":    expectedLocalProperty,
 				"tmpl_property_override": expectedGlobalPropertyOverride,
 			},
@@ -141,7 +146,7 @@ ENV GLOBAL_PROPERTY_OVERRIDE %s
 	if err != nil {
 		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
 	}
-	dockerfile := path.Join(tmpDir, GeneratedDockerFileNamePrefix+image.ImageConfig.TagPrefix)
+	dockerfile := path.Join(tmpDir, DefaultRenderDir, image.ImageConfig.Id, GeneratedDockerfileName)
 
 	_, err = os.Stat(dockerfile)
 	if os.IsNotExist(err) {
@@ -159,6 +164,265 @@ ENV GLOBAL_PROPERTY_OVERRIDE %s
 	}
 }
 
+func TestTemplatePropertyOverrides(t *testing.T) {
+	template := `FROM ubuntu:{{ubuntu_version}}
+ENV VERSION {{version}}
+ENV SPARK_VERSION {{spark_version}}
+`
+	expectedDockerfile := `FROM ubuntu:20.04
+ENV VERSION 2.0.0
+ENV SPARK_VERSION 3.0.0
+`
+
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	tmpFile, err := os.Create(path.Join(tmpDir, "Dockerfile.template"))
+	if err != nil {
+		t.Errorf("Failed to create file: %v", err)
+	}
+	_, err = tmpFile.Write([]byte(template))
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	buildConfig := BuildConfig{
+		BaseDir: tmpDir,
+		GlobalProperties: Properties{
+			"version": "1.0.0",
+		},
+		PropertyOverrides: []PropertyOverride{
+			{Image: "image", Key: "spark_version", Value: "3.0.0", Source: "--set"},
+			{Key: "version", Value: "2.0.0", Source: "--set"},
+			{Key: "spark_version", Value: "2.4.5", Source: "--set"},
+			{Image: "other", Key: "version", Value: "3.0.0", Source: "--set"},
+		},
+	}
+
+	image := Image{
+		ImageConfig: ImageConfig{
+			Id:       "image",
+			Template: tmpFile.Name(),
+			Properties: Properties{
+				"ubuntu_version": "20.04",
+				"version":        "1.5.0",
+			},
+		},
+	}
+
+	err = image.RenderDockerfileFromTemplate(buildConfig)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	bytes, err := ioutil.ReadFile(image.Dockerfile)
+	if err != nil {
+		t.Errorf("Failed to read bytes from file %s: %v", image.Dockerfile, err)
+	}
+	contents := string(bytes)
+
+	if strings.Compare(expectedDockerfile, contents) != 0 {
+		t.Errorf("Rendered Dockerfile contents differ from the expected.\nExpected:\n%s\nRendered:\n%s", expectedDockerfile, contents)
+	}
+}
+
+func TestRenderStructuredTemplateProperties(t *testing.T) {
+	template := `FROM ubuntu
+ENV JAVA_HOME {{settings.java.home}}
+{{#pip_packages}}
+RUN pip install {{.}}
+{{/pip_packages}}
+`
+	expectedDockerfile := `FROM ubuntu
+ENV JAVA_HOME /opt/java
+RUN pip install numpy
+RUN pip install pandas
+`
+
+	tmpDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	tmpFile, err := os.Create(path.Join(tmpDir, "Dockerfile.template"))
+	if err != nil {
+		t.Errorf("Failed to create file: %v", err)
+	}
+	_, err = tmpFile.Write([]byte(template))
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := Image{
+		ImageConfig: ImageConfig{
+			Id:       "image",
+			Template: tmpFile.Name(),
+			Properties: Properties{
+				"pip_packages": []interface{}{"numpy", "pandas"},
+				"settings": map[string]interface{}{
+					"java": map[string]interface{}{"home": "/opt/java"},
+				},
+			},
+		},
+	}
+
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	bytes, err := ioutil.ReadFile(image.Dockerfile)
+	if err != nil {
+		t.Errorf("Failed to read bytes from file %s: %v", image.Dockerfile, err)
+	}
+	contents := string(bytes)
+
+	if strings.Compare(expectedDockerfile, contents) != 0 {
+		t.Errorf("Rendered Dockerfile contents differ from the expected.\nExpected:\n%s\nRendered:\n%s", expectedDockerfile, contents)
+	}
+}
+
+func TestRenderTemplatedFiles(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "base")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	templateFile := path.Join(baseDir, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte("FROM ubuntu\nCOPY {{generated_dir}}/testdata/templated/app-env.sh /app-env.sh\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	image := Image{
+		ImageConfig: ImageConfig{
+			Id:             "app",
+			Template:       templateFile,
+			TemplatedFiles: []string{"testdata/templated/app-env.sh"},
+			Properties:     Properties{"version": "1.0"},
+		},
+	}
+	config := BuildConfig{BaseDir: baseDir}
+
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+
+	assertFileContents(t, image.Dockerfile, "FROM ubuntu\nCOPY .cake/generated/app/testdata/templated/app-env.sh /app-env.sh\n")
+
+	generatedFile := path.Join(baseDir, ".cake/generated/app/testdata/templated/app-env.sh")
+	assertFileContents(t, generatedFile, "#!/bin/bash\nexport APP_VERSION=1.0\n")
+
+	expectedFiles := []string{generatedFile}
+	if !reflect.DeepEqual(expectedFiles, image.GeneratedFiles) {
+		t.Errorf("Generated files differ from the expected.\nExpected:\n%s\nFound:\n%s", expectedFiles, image.GeneratedFiles)
+	}
+
+	info, err := os.Stat(generatedFile)
+	if err != nil {
+		t.Errorf("Unable to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("Expected permissions of the templated file to be preserved but found: %v", info.Mode())
+	}
+
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	checksum := image.Checksum
+
+	//changing a property used only in a templated file must change the checksum
+	image.ImageConfig.Properties["version"] = "2.0"
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	if checksum == image.Checksum {
+		t.Errorf("Expected checksum to change after changing a property used in a templated file")
+	}
+
+	image.ImageConfig.TemplatedFiles = []string{"../outside.sh"}
+	err = image.RenderDockerfileFromTemplate(config)
+	if err == nil {
+		t.Errorf("Expected an error for a templated file outside of the project directory")
+	}
+}
+
+func TestRenderPlainDockerfile(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "base")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	dockerfile := path.Join(baseDir, "Dockerfile")
+	err = ioutil.WriteFile(dockerfile, []byte("ARG PARENT_IMAGE\nFROM ${PARENT_IMAGE}\nARG version\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	root := &Image{ImageConfig: ImageConfig{Id: "root", Repository: "repo", Name: "root"}, Checksum: "abc"}
+	image := &Image{
+		ImageConfig: ImageConfig{
+			Id:         "app",
+			Dockerfile: dockerfile,
+			Properties: Properties{"version": "1.0", "packages": []interface{}{"curl"}},
+		},
+		Parent: root,
+	}
+	config := BuildConfig{BaseDir: baseDir}
+
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering plain Dockerfile: %v", err)
+	}
+
+	if image.Dockerfile != dockerfile {
+		t.Errorf("Expected plain Dockerfile to be used as is but found %s", image.Dockerfile)
+	}
+	expectedArgs := map[string]string{
+		"PARENT_IMAGE": "repo/root:abc",
+		"version":      "1.0",
+		"packages":     `["curl"]`,
+	}
+	if !reflect.DeepEqual(expectedArgs, image.BuildArgs) {
+		t.Errorf("Build arguments differ from the expected.\nExpected:\n%v\nFound:\n%v", expectedArgs, image.BuildArgs)
+	}
+
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	checksum := image.Checksum
+
+	//changing a property passed as a build argument must change the checksum
+	image.ImageConfig.Properties["version"] = "2.0"
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering plain Dockerfile: %v", err)
+	}
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	if checksum == image.Checksum {
+		t.Errorf("Expected checksum to change after changing a property passed as a build argument")
+	}
+
+	image.ImageConfig.Template = path.Join(baseDir, "Dockerfile.template")
+	err = image.RenderDockerfileFromTemplate(config)
+	if err == nil {
+		t.Errorf("Expected an error for an image with both a template and a plain Dockerfile")
+	}
+}
+
 func TestErrorOnRenderingMissingTemplateProperties(t *testing.T) {
 	template := `FROM {{parent}}
 ENV PROPERTY {{property}}
@@ -189,7 +453,7 @@ ENV PROPERTY {{property}}
 		},
 	}
 
-	err = image.RenderDockerfileFromTemplate(BuildConfig{})
+	err = image.RenderDockerfileFromTemplate(BuildConfig{BaseDir: tmpDir})
 	if err == nil {
 		t.Errorf("Expected error while rendering Dockerfile from template with missing variables")
 	}
@@ -294,6 +558,7 @@ func TestCalculateChecksum(t *testing.T) {
 	image := Image{
 		Dockerfile: dockerfile,
 		ImageConfig: ImageConfig{
+			Template:   path.Join(source, "main", "Dockerfile.template"),
 			ExtraFiles: []string{path.Join(source, "shared")},
 		},
 	}
@@ -321,6 +586,7 @@ func TestChecksumWithExcludedFiles(t *testing.T) {
 	image := Image{
 		Dockerfile: dockerfile,
 		ImageConfig: ImageConfig{
+			Template:      path.Join(source, "main", "Dockerfile.template"),
 			ExcludedFiles: []string{path.Join(source, "main", "external")},
 		},
 	}
@@ -335,55 +601,313 @@ func TestChecksumWithExcludedFiles(t *testing.T) {
 	}
 }
 
-func TestCalculateChecksumWithMultipleDockerfiles(t *testing.T) {
-	primaryDockerfileContents := `FROM ubuntu:18.04
+func TestChecksumWithSharedTemplate(t *testing.T) {
+	template := `FROM ubuntu:{{ubuntu_version}}
 COMMAND echo "Hello world"
 `
-	secondaryDockerfileContents := `FROM ubuntu:18.10
+
+	/*
+	   Rendering a single template for two images into the following folder structure:
+	   root/
+	       image/
+	           - Dockerfile.template
+	       .cake/generated/
+	           primary/
+	               - Dockerfile
+	           secondary/
+	               - Dockerfile
+	*/
+
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+	templateFile := path.Join(root, "image", "Dockerfile.template")
+	err = os.MkdirAll(path.Dir(templateFile), os.ModePerm)
+	if err != nil {
+		t.Errorf("Failed to create directory: %v", err)
+	}
+	err = ioutil.WriteFile(templateFile, []byte(template), 0644)
+	if err != nil {
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	config := BuildConfig{BaseDir: root}
+	images := []*Image{
+		{ImageConfig: ImageConfig{Id: "primary", Template: templateFile, Properties: Properties{"ubuntu_version": "18.04"}}},
+		{ImageConfig: ImageConfig{Id: "secondary", Template: templateFile, Properties: Properties{"ubuntu_version": "18.10"}}},
+	}
+	for _, image := range images {
+		err = image.RenderDockerfileFromTemplate(config)
+		if err != nil {
+			t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+		}
+	}
+
+	for _, image := range images {
+		contents, err := ioutil.ReadFile(image.Dockerfile)
+		if err != nil {
+			t.Errorf("Unable to read file: %v", err)
+		}
+
+		//only the template and the Dockerfile rendered for the image itself are included in the checksum
+		expectedChecksum := checksum(checksum(string(contents)) + checksum(template))
+
+		err = image.CalculateChecksum(DefaultShaLength)
+		if err != nil {
+			t.Errorf("Unexpected error while calculating checksum: %v", err)
+		}
+
+		if expectedChecksum != image.Checksum {
+			t.Errorf("Calculated image checksum differs from the expected.\nExpected:\n%s\nCalculated:\n%s", expectedChecksum, image.Checksum)
+		}
+	}
+
+	if images[0].Checksum == images[1].Checksum {
+		t.Errorf("Expected images rendered with different properties to have different checksums")
+	}
+}
+
+func TestChecksumWithCommittedDockerfiles(t *testing.T) {
+	template := `FROM ubuntu:{{ubuntu_version}}
 COMMAND echo "Hello world"
 `
 
 	/*
-	   Creating the following folder structure with non-empty files:
+	   Rendering a single template for a parent and a child image with committed Dockerfiles:
 	   root/
 	       image/
-	           - Dockerfile.generated
-	           - Dockerfile.generated.secondary
+	           - Dockerfile.template
+	           - Dockerfile.generated.parent
+	           - Dockerfile.generated.child
 	*/
 
 	root, err := ioutil.TempDir("", "")
 	if err != nil {
 		t.Errorf("Failed to create temporary directory: %v", err)
 	}
-	baseDir, err := ioutil.TempDir(root, "image")
+	defer os.RemoveAll(root)
+	templateFile := path.Join(root, "image", "Dockerfile.template")
+	err = os.MkdirAll(path.Dir(templateFile), os.ModePerm)
 	if err != nil {
-		t.Errorf("Failed to create temporary directory: %v", err)
+		t.Errorf("Failed to create directory: %v", err)
 	}
-	primaryDockerfile, err := os.Create(path.Join(baseDir, GeneratedDockerFileNamePrefix))
+	err = ioutil.WriteFile(templateFile, []byte(template), 0644)
 	if err != nil {
-		t.Errorf("Failed to create file: %v", err)
+		t.Errorf("Failed to write file: %v", err)
+	}
+
+	config := BuildConfig{BaseDir: root}
+	parent := &Image{ImageConfig: ImageConfig{Id: "parent", Template: templateFile, TagSuffix: "parent",
+		Properties: Properties{"ubuntu_version": "18.04"}}}
+	child := &Image{Parent: parent, ImageConfig: ImageConfig{Id: "child", Template: templateFile, TagSuffix: "child",
+		Properties: Properties{"ubuntu_version": "18.10"}}}
+	parent.Children = []*Image{child}
+
+	images := []*Image{parent, child}
+	for _, image := range images {
+		err = image.RenderDockerfileFromTemplate(config)
+		if err != nil {
+			t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+		}
+		err = ioutil.WriteFile(image.committedDockerfile(), []byte("FROM ubuntu:"+image.ImageConfig.TagSuffix), 0644)
+		if err != nil {
+			t.Errorf("Failed to write file: %v", err)
+		}
+	}
+
+	for _, image := range images {
+		contents, err := ioutil.ReadFile(image.Dockerfile)
+		if err != nil {
+			t.Errorf("Unable to read file: %v", err)
+		}
+
+		//committed Dockerfiles of both images are excluded from the checksum
+		expectedChecksum := checksum(checksum(string(contents)) + checksum(template))
+
+		err = image.CalculateChecksum(DefaultShaLength)
+		if err != nil {
+			t.Errorf("Unexpected error while calculating checksum: %v", err)
+		}
+
+		if expectedChecksum != image.Checksum {
+			t.Errorf("Calculated image checksum differs from the expected.\nExpected:\n%s\nCalculated:\n%s", expectedChecksum, image.Checksum)
+		}
 	}
-	_, err = primaryDockerfile.Write([]byte(primaryDockerfileContents))
+}
+
+func TestChecksumWithRenderDirInsideTemplateDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+	templateFile := path.Join(root, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte("FROM ubuntu:{{ubuntu_version}}\n"), 0644)
 	if err != nil {
 		t.Errorf("Failed to write file: %v", err)
 	}
 
-	secondaryDockerfile, err := os.Create(path.Join(baseDir, GeneratedDockerFileNamePrefix+".secondary"))
+	config := BuildConfig{BaseDir: root}
+	primary := &Image{ImageConfig: ImageConfig{Id: "primary", Template: templateFile, Properties: Properties{"ubuntu_version": "18.04"}}}
+	err = primary.RenderDockerfileFromTemplate(config)
 	if err != nil {
-		t.Errorf("Failed to create file: %v", err)
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
 	}
-	_, err = secondaryDockerfile.Write([]byte(secondaryDockerfileContents))
+	err = primary.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	expectedChecksum := primary.Checksum
+
+	//rendering another image into the same render directory must not affect the checksum
+	secondary := &Image{ImageConfig: ImageConfig{Id: "secondary", Template: templateFile, Properties: Properties{"ubuntu_version": "18.10"}}}
+	err = secondary.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	err = primary.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+
+	if expectedChecksum != primary.Checksum {
+		t.Errorf("Calculated image checksum differs from the expected.\nExpected:\n%s\nCalculated:\n%s", expectedChecksum, primary.Checksum)
+	}
+}
+
+func TestCleanRenderDirectory(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+	templateFile := path.Join(root, "Dockerfile.template")
+	err = ioutil.WriteFile(templateFile, []byte("FROM ubuntu\n"), 0644)
 	if err != nil {
 		t.Errorf("Failed to write file: %v", err)
 	}
 
-	expectedChecksum := checksum(checksum(secondaryDockerfileContents))
+	renderDir := path.Join(root, "rendered")
+	config := BuildConfig{BaseDir: root, RenderDir: "rendered"}
+	image := &Image{ImageConfig: ImageConfig{Id: "image", Template: templateFile}}
+	err = image.RenderDockerfileFromTemplate(config)
+	if err != nil {
+		t.Errorf("Unexpected error while rendering Dockerfile from template: %v", err)
+	}
+	assertFileContents(t, path.Join(renderDir, "image", GeneratedDockerfileName), "FROM ubuntu\n")
+
+	err = CleanRenderDirectory(config)
+	if err != nil {
+		t.Errorf("Unexpected error while cleaning up render directory: %v", err)
+	}
+	if _, err = os.Stat(renderDir); !os.IsNotExist(err) {
+		t.Errorf("Expected render directory %s to be removed", renderDir)
+	}
+	if _, err = os.Stat(templateFile); err != nil {
+		t.Errorf("Expected template %s to be preserved: %v", templateFile, err)
+	}
+
+	//image IDs must not escape the render directory
+	for _, id := range []string{"../..", "..", ".", "nested/image"} {
+		traversal := &Image{ImageConfig: ImageConfig{Id: id, Template: templateFile}}
+		err = traversal.RenderDockerfileFromTemplate(config)
+		if err == nil {
+			t.Errorf("Expected an error for image ID '%s'", id)
+		}
+	}
+	if _, err = os.Stat(templateFile); err != nil {
+		t.Errorf("Expected template %s to be preserved: %v", templateFile, err)
+	}
+
+	//render directory must be located within the build context
+	config.RenderDir = path.Join(os.TempDir(), "outside")
+	err = image.RenderDockerfileFromTemplate(config)
+	if err == nil {
+		t.Errorf("Expected an error for a render directory outside of the build context")
+	}
+
+	//the project directory itself and directories outside of it are never removed
+	for _, directory := range []string{".", root, "..", path.Join(os.TempDir(), "outside")} {
+		config.RenderDir = directory
+		err = CleanRenderDirectory(config)
+		if err == nil {
+			t.Errorf("Expected an error while cleaning up render directory %s", directory)
+		}
+	}
+	if _, err = os.Stat(templateFile); err != nil {
+		t.Errorf("Expected template %s to be preserved: %v", templateFile, err)
+	}
+}
+
+func TestChecksumWithStructuredProperties(t *testing.T) {
+	source := "testdata/basic"
+	dockerfile := path.Join(source, "main", "Dockerfile.generated")
+	nestedFile := path.Join(source, "main", "nested", "nested.file")
+
+	dockerFileContents, err := ioutil.ReadFile(dockerfile)
+	if err != nil {
+		t.Errorf("Unable to read file: %v", err)
+	}
+
+	nestedFileContents, err := ioutil.ReadFile(nestedFile)
+	if err != nil {
+		t.Errorf("Unable to read file: %v", err)
+	}
+
+	structuredValues := `{"packages":["numpy","pandas"],"settings":{"a":"1","b":"2"}}`
+	expectedChecksum := checksum(checksum(string(dockerFileContents)) + checksum(string(nestedFileContents)) + checksum(structuredValues))
+
+	image := Image{
+		Dockerfile:  dockerfile,
+		ImageConfig: ImageConfig{Template: path.Join(source, "main", "Dockerfile.template")},
+		Properties:  newPropertyScope(),
+	}
+	image.Properties.set("version", "1.0", "global")
+	image.Properties.set("packages", []interface{}{"numpy", "pandas"}, "global")
+	image.Properties.set("settings", map[string]interface{}{"b": "2", "a": "1"}, "global")
+
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+
+	if expectedChecksum != image.Checksum {
+		t.Errorf("Calculated image checksum differs from the expected.\nExpected:\n%s\nCalculated:\n%s", expectedChecksum, image.Checksum)
+	}
+}
+
+func TestChecksumWithBuildSettings(t *testing.T) {
+	source := "testdata/basic"
+	dockerfile := path.Join(source, "main", "Dockerfile.generated")
+	nestedFile := path.Join(source, "main", "nested", "nested.file")
+
+	dockerFileContents, err := ioutil.ReadFile(dockerfile)
+	if err != nil {
+		t.Errorf("Unable to read file: %v", err)
+	}
+
+	nestedFileContents, err := ioutil.ReadFile(nestedFile)
+	if err != nil {
+		t.Errorf("Unable to read file: %v", err)
+	}
+
+	settings := `{"build_args":{"VERSION":"1.0"},"labels":{"team":"data"},"platform":"linux/amd64","target":"runtime"}`
+	expectedChecksum := checksum(checksum(string(dockerFileContents)) + checksum(string(nestedFileContents)) + checksum(settings))
 
 	image := Image{
-		Dockerfile: secondaryDockerfile.Name(),
+		Dockerfile: dockerfile,
 		ImageConfig: ImageConfig{
-			TagPrefix: "secondary",
+			Template:    path.Join(source, "main", "Dockerfile.template"),
+			Target:      "runtime",
+			Labels:      map[string]string{"team": "data"},
+			Platform:    "linux/amd64",
+			NetworkMode: "host",
+			NoCache:     true,
 		},
+		BuildArgs:  map[string]string{"VERSION": "1.0"},
+		Properties: newPropertyScope(),
 	}
 
 	err = image.CalculateChecksum(DefaultShaLength)
@@ -394,6 +918,27 @@ COMMAND echo "Hello world"
 	if expectedChecksum != image.Checksum {
 		t.Errorf("Calculated image checksum differs from the expected.\nExpected:\n%s\nCalculated:\n%s", expectedChecksum, image.Checksum)
 	}
+
+	//settings which don't affect image contents must not change the checksum
+	image.ImageConfig.NetworkMode = ""
+	image.ImageConfig.NoCache = false
+	image.ImageConfig.Pull = true
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	if expectedChecksum != image.Checksum {
+		t.Errorf("Expected checksum not to change after changing network_mode, no_cache and pull")
+	}
+
+	image.ImageConfig.Target = "debug"
+	err = image.CalculateChecksum(DefaultShaLength)
+	if err != nil {
+		t.Errorf("Unexpected error while calculating checksum: %v", err)
+	}
+	if expectedChecksum == image.Checksum {
+		t.Errorf("Expected checksum to change after changing the build target")
+	}
 }
 
 func TestTruncateChecksum(t *testing.T) {
@@ -415,7 +960,8 @@ func TestTruncateChecksum(t *testing.T) {
 	expectedChecksum := checksum(checksum(string(dockerFileContents)) + checksum(string(nestedFileContents)))[:testShaLength]
 
 	image := Image{
-		Dockerfile: dockerfile,
+		Dockerfile:  dockerfile,
+		ImageConfig: ImageConfig{Template: path.Join(source, "main", "Dockerfile.template")},
 	}
 
 	err = image.CalculateChecksum(testShaLength)